@@ -0,0 +1,223 @@
+// Copyright 2019 The Cloud Robotics Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// chartctl is a developer CLI around the ChartAssignment CRD.
+//
+// chartctl diff <file.yaml> loads the ChartAssignment's chart and merges its
+// values locally (its templates are not rendered locally), then submits the assignment to the apiserver with Spec.DryRun set so the
+// controller reconciles it without applying anything, waits for it to
+// populate Status.Preview, prints the structured diff, and deletes the
+// ChartAssignment again.
+//
+// A server-side dryRun=All create is not enough here: the preview is
+// computed by the controller's normal reconcile loop, which never runs
+// against an object the apiserver only validated and discarded.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	apps "github.com/googlecloudrobotics/core/src/go/pkg/apis/apps/v1alpha1"
+	"github.com/googlecloudrobotics/core/src/go/pkg/controller/chartassignment"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+var chartAssignmentResource = schema.GroupVersionResource{
+	Group:    apps.SchemeGroupVersion.Group,
+	Version:  apps.SchemeGroupVersion.Version,
+	Resource: "chartassignments",
+}
+
+const (
+	colorGreen  = "\033[32m"
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 2 || args[0] != "diff" {
+		usage()
+		os.Exit(2)
+	}
+	if err := runDiff(args[1]); err != nil {
+		fmt.Fprintln(os.Stderr, "chartctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s diff <file.yaml>\n", os.Args[0])
+}
+
+// previewTimeout bounds how long runDiff waits for the controller to
+// reconcile the dry-run ChartAssignment and populate Status.Preview.
+const previewTimeout = 30 * time.Second
+
+// runDiff loads the ChartAssignment from file, loads its chart and merges
+// its values locally to catch chart source and values errors early (template
+// errors are only reported by the controller), then creates a temporary copy of it with
+// Spec.DryRun set, waits for the controller to populate Status.Preview,
+// prints it, and deletes the temporary copy again.
+//
+// The diff is meant to preview changes to an already-deployed
+// ChartAssignment, so the file handed to chartctl is typically an edited
+// copy of a live object's YAML. Creating under its literal Name would
+// therefore collide with that live object and fail with AlreadyExists;
+// the temporary copy is created under a generated name instead.
+func runDiff(file string) error {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", file, err)
+	}
+	var as apps.ChartAssignment
+	if err := yaml.Unmarshal(raw, &as); err != nil {
+		return fmt.Errorf("parsing %s: %v", file, err)
+	}
+
+	if _, _, err := chartassignment.LoadChart(&as.Spec.Chart); err != nil {
+		return fmt.Errorf("rendering chart: %v", err)
+	}
+
+	client, err := newDynamicClient()
+	if err != nil {
+		return err
+	}
+	res := client.Resource(chartAssignmentResource).Namespace(as.Namespace)
+
+	created, err := createDryRunAssignment(context.Background(), res, &as)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := res.Delete(context.Background(), created.GetName(), metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			fmt.Fprintf(os.Stderr, "chartctl: cleaning up dry-run ChartAssignment %s: %v\n", created.GetName(), err)
+		}
+	}()
+
+	preview, err := waitForPreview(res, created.GetName())
+	if err != nil {
+		return err
+	}
+	printPreview(preview)
+	return nil
+}
+
+// createDryRunAssignment creates a temporary, dry-run copy of as. Diffing
+// is meant to preview changes to an already-deployed ChartAssignment, so as
+// typically carries the Name of that live object; creating under that
+// literal Name would collide with it and fail with AlreadyExists, so the
+// copy is created under a name generated from it instead.
+func createDryRunAssignment(ctx context.Context, res dynamic.ResourceInterface, as *apps.ChartAssignment) (*unstructured.Unstructured, error) {
+	as.Spec.DryRun = true
+	as.GenerateName = as.Name + "-chartctl-diff-"
+	as.Name = ""
+
+	u, err := toUnstructured(as)
+	if err != nil {
+		return nil, fmt.Errorf("converting ChartAssignment: %v", err)
+	}
+	created, err := res.Create(ctx, u, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("creating dry-run ChartAssignment: %v", err)
+	}
+	return created, nil
+}
+
+// waitForPreview polls the named ChartAssignment until the controller has
+// reconciled it and either populated Status.Preview or reported a failure
+// condition, or previewTimeout elapses. Any condition with status True
+// seen before a preview (e.g. ConditionApplyConflict) is surfaced as an
+// error rather than being mistaken for "no changes".
+func waitForPreview(res dynamic.ResourceInterface, name string) (*apps.Preview, error) {
+	var preview *apps.Preview
+	err := wait.PollImmediate(500*time.Millisecond, previewTimeout, func() (bool, error) {
+		obj, err := res.Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("getting dry-run ChartAssignment: %v", err)
+		}
+		var result apps.ChartAssignment
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &result); err != nil {
+			return false, fmt.Errorf("decoding dry-run result: %v", err)
+		}
+		if result.Status.Preview != nil {
+			preview = result.Status.Preview
+			return true, nil
+		}
+		for _, c := range result.Status.Conditions {
+			if c.Status == metav1.ConditionTrue {
+				return false, fmt.Errorf("controller reported %s: %s", c.Type, c.Message)
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("waiting for controller to compute preview: %v", err)
+	}
+	return preview, nil
+}
+
+func newDynamicClient() (dynamic.Interface, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig: %v", err)
+	}
+	return dynamic.NewForConfig(cfg)
+}
+
+func toUnstructured(as *apps.ChartAssignment) (*unstructured.Unstructured, error) {
+	as.TypeMeta = metav1.TypeMeta{APIVersion: apps.SchemeGroupVersion.String(), Kind: "ChartAssignment"}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(as)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
+
+// printPreview renders a Preview as a colorized unified diff: additions in
+// green, removals in red, and per-object patches in yellow.
+func printPreview(p *apps.Preview) {
+	if p == nil {
+		fmt.Println("No changes.")
+		return
+	}
+	for _, ref := range p.Added {
+		fmt.Printf("%s+ %s %s %s/%s%s\n", colorGreen, ref.APIVersion, ref.Kind, ref.Namespace, ref.Name, colorReset)
+	}
+	for _, ref := range p.Removed {
+		fmt.Printf("%s- %s %s %s/%s%s\n", colorRed, ref.APIVersion, ref.Kind, ref.Namespace, ref.Name, colorReset)
+	}
+	for _, patch := range p.Changed {
+		fmt.Printf("%s~ %s %s %s/%s%s\n", colorYellow, patch.APIVersion, patch.Kind, patch.Namespace, patch.Name, colorReset)
+		fmt.Println(patch.Patch)
+	}
+}
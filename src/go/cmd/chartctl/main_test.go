@@ -0,0 +1,58 @@
+// Copyright 2019 The Cloud Robotics Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	apps "github.com/googlecloudrobotics/core/src/go/pkg/apis/apps/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func Test_createDryRunAssignment_avoidsCollisionWithLiveObjectOfSameName(t *testing.T) {
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	res := client.Resource(chartAssignmentResource).Namespace("default")
+
+	live, err := toUnstructured(&apps.ChartAssignment{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-app", Namespace: "default"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := res.Create(context.Background(), live, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("seeding the live ChartAssignment: %v", err)
+	}
+
+	as := &apps.ChartAssignment{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-app", Namespace: "default"},
+	}
+	created, err := createDryRunAssignment(context.Background(), res, as)
+	if err != nil {
+		t.Fatalf("diffing a ChartAssignment with the same name as a live one: %v", err)
+	}
+	if created.GetName() == "" || created.GetName() == "my-app" {
+		t.Fatalf("got created name %q, want a name generated from %q", created.GetName(), "my-app")
+	}
+	if !as.Spec.DryRun {
+		t.Fatal("createDryRunAssignment should set Spec.DryRun")
+	}
+
+	if _, err := res.Get(context.Background(), "my-app", metav1.GetOptions{}); err != nil {
+		t.Fatalf("the live object should be untouched: %v", err)
+	}
+}
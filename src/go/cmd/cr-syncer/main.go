@@ -45,7 +45,10 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
 	"contrib.go.opencensus.io/exporter/prometheus"
@@ -57,14 +60,22 @@ import (
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	corev1 "k8s.io/api/core/v1"
 	crdtypes "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apiserver/pkg/server/egressselector"
 	crdclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	crdinformer "k8s.io/apiextensions-apiserver/pkg/client/informers/externalversions"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 )
 
@@ -73,6 +84,10 @@ const (
 	// to be sent as updates once again, which will trigger reconciliation on those
 	// objects and thus fix any potential drift.
 	resyncPeriod = 5 * time.Minute
+
+	// leaderElectionResourceName identifies the lease that guards which
+	// syncer replica is currently active.
+	leaderElectionResourceName = "cr-syncer-leader-election"
 )
 
 var (
@@ -84,6 +99,15 @@ var (
 	timeout            = flag.Int64("timeout", 300, "Timeout for CR watch calls in seconds")
 	namespace          = flag.String("namespace", metav1.NamespaceDefault, "Namespace which namespaced resources are synced")
 
+	egressSelectorConfigFile = flag.String("egress-selector-config", "", "Path to an EgressSelectorConfiguration file describing how to dial the remote cluster (direct, http-connect, or grpc/UDS konnectivity proxy). If unset, the remote server is dialed directly")
+
+	leaderElect              = flag.Bool("leader-elect", true, "Enable leader election so only one replica of the syncer is active at a time")
+	leaderElectResourceLock  = flag.String("leader-elect-resource-lock", resourcelock.LeasesResourceLock, "Resource lock type used for leader election")
+	leaderElectNamespace     = flag.String("leader-elect-namespace", metav1.NamespaceDefault, "Namespace in which the leader election resource is created")
+	leaderElectLeaseDuration = flag.Duration("leader-elect-lease-duration", 15*time.Second, "Duration that non-leader candidates will wait before forcing acquisition of leadership")
+	leaderElectRenewDeadline = flag.Duration("leader-elect-renew-deadline", 10*time.Second, "Duration the acting leader will retry refreshing leadership before giving up")
+	leaderElectRetryPeriod   = flag.Duration("leader-elect-retry-period", 2*time.Second, "Duration clients should wait between tries of actions")
+
 	sizeDistribution    = view.Distribution(0, 1024, 2048, 4096, 16384, 65536, 262144, 1048576, 4194304, 33554432)
 	latencyDistribution = view.Distribution(0, 1, 2, 5, 10, 15, 25, 50, 100, 200, 400, 800, 1500, 3000, 6000)
 
@@ -136,6 +160,40 @@ func (r *ctxRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	return r.base.RoundTrip(req.WithContext(r.ctx))
 }
 
+// dialerForEgressSelector loads an EgressSelectorConfiguration from path and
+// returns the dial function configured for the "cluster" egress type, which
+// is the same type controller-runtime style proxy tunnels (e.g. a
+// konnectivity agent on the robot) register themselves under. It tags
+// every dial with location=remote, matching ctxRoundTripper's HTTP tagging.
+//
+// Lookup returns (nil, nil) for the "direct" backend, same as
+// kube-apiserver's egress selector: there is no tunnel to dial through, so
+// the caller should keep using the REST config's default dialer. In that
+// case dialerForEgressSelector also returns (nil, nil).
+func dialerForEgressSelector(path string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	cfg, err := egressselector.ReadEgressSelectorConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading egress selector config %s: %v", path, err)
+	}
+	selector, err := egressselector.NewEgressSelector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("building egress selector from %s: %v", path, err)
+	}
+	dial, err := selector.Lookup(egressselector.Cluster)
+	if err != nil {
+		return nil, fmt.Errorf("no egress dialer configured for egress type %q: %v", egressselector.Cluster, err)
+	}
+	if dial == nil {
+		return nil, nil
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if tagged, err := tag.New(ctx, tag.Insert(tagLocation, "remote")); err == nil {
+			ctx = tagged
+		}
+		return dial(ctx, network, addr)
+	}, nil
+}
+
 // restConfigForRemote assembles the K8s REST config for the remote server.
 func restConfigForRemote(ctx context.Context) (*rest.Config, error) {
 	tokenSource, err := google.DefaultTokenSource(ctx, "https://www.googleapis.com/auth/cloud-platform")
@@ -157,13 +215,23 @@ func restConfigForRemote(ctx context.Context) (*rest.Config, error) {
 		rt = &ochttp.Transport{Base: rt}
 		return &ctxRoundTripper{base: rt, ctx: ctx}
 	}
-	return &rest.Config{
+	config := &rest.Config{
 		Host:          fmt.Sprintf("https://%s", *remoteServer),
 		WrapTransport: transport,
 		// The original value of timeout is set in the options of lister and watcher in newInformer function. This timeout is not enforced by the client.
 		// That's the reason for the timeout in REST config. It is set to timeout + 5 seconds to give some time for a graceful closing of the connection.
 		Timeout: time.Second * (time.Duration(*timeout) + 5),
-	}, nil
+	}
+	if *egressSelectorConfigFile != "" {
+		dial, err := dialerForEgressSelector(*egressSelectorConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		if dial != nil {
+			config.Dial = dial
+		}
+	}
+	return config, nil
 }
 
 type CrdChange struct {
@@ -197,10 +265,125 @@ func streamCrds(done <-chan struct{}, clientset crdclientset.Interface, crds cha
 	return nil
 }
 
+// runSyncers streams CRD changes from the local cluster and keeps one
+// crSyncer per CRD up to date with it, until ctx is cancelled. On return,
+// all syncers it started have been stopped.
+//
+// CRD modifications that only change the cr-syncer annotations are applied
+// in place via crSyncer.reconfigure, reusing the syncer's informers instead
+// of rebuilding them; the informers themselves are shared process-wide for
+// each cluster via localCache/remoteCache.
+func runSyncers(ctx context.Context, localConfig *rest.Config, local, remote dynamic.Interface) {
+	crds := make(chan CrdChange)
+	if err := streamCrds(ctx.Done(), crdclientset.NewForConfigOrDie(localConfig), crds); err != nil {
+		log.Fatalf("Unable to stream CRDs from local Kubernetes: %v", err)
+	}
+	localCache := newInformerCache(local, "local", ctx.Done())
+	remoteCache := newInformerCache(remote, "remote", ctx.Done())
+
+	syncers := make(map[string]*crSyncer)
+	defer func() {
+		for name, s := range syncers {
+			s.stop()
+			delete(syncers, name)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case crd, ok := <-crds:
+			if !ok {
+				return
+			}
+			name := crd.CRD.GetName()
+
+			if cur, ok := syncers[name]; ok {
+				gvr, gvrErr := gvrForCRD(crd.CRD)
+				if crd.Type == watch.Modified && gvrErr == nil && gvr == cur.gvr {
+					if err := cur.reconfigure(*crd.CRD); err != nil {
+						log.Printf("skipping reconfiguration of %s: %s", name, err)
+					}
+					continue
+				}
+				if crd.Type == watch.Added {
+					log.Printf("Warning: Already had a running sync for freshly added %s", name)
+				}
+				cur.stop()
+				delete(syncers, name)
+			}
+			if crd.Type == watch.Added || crd.Type == watch.Modified {
+				s, err := newCRSyncer(ctx, *crd.CRD, localCache, remoteCache, local, remote, *robotName)
+				if err != nil {
+					log.Printf("skipping custom resource %s: %s", name, err)
+					continue
+				}
+				syncers[name] = s
+				go s.run()
+			}
+		}
+	}
+}
+
+// leaderElectionIdentity returns the lock holder identity to use for this
+// process, preferring the pod name so that identities stay stable and
+// human-readable in a Deployment.
+func leaderElectionIdentity() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return "cr-syncer"
+	}
+	return host
+}
+
+func corev1EventSource() corev1.EventSource {
+	return corev1.EventSource{Component: "cr-syncer"}
+}
+
+func newLeaderElector(kubeClient kubernetes.Interface, identity string, run func(ctx context.Context), stopped func()) (*leaderelection.LeaderElector, error) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: kubeClient.CoreV1().Events(*leaderElectNamespace),
+	})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1EventSource())
+
+	lock, err := resourcelock.New(
+		*leaderElectResourceLock,
+		*leaderElectNamespace,
+		leaderElectionResourceName,
+		kubeClient.CoreV1(),
+		kubeClient.CoordinationV1(),
+		resourcelock.ResourceLockConfig{
+			Identity:      identity,
+			EventRecorder: recorder,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating leader election lock: %v", err)
+	}
+
+	return leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   *leaderElectLeaseDuration,
+		RenewDeadline:   *leaderElectRenewDeadline,
+		RetryPeriod:     *leaderElectRetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: run,
+			OnStoppedLeading: stopped,
+		},
+	})
+}
+
 func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	localConfig, err := rest.InClusterConfig()
 	if err != nil {
@@ -246,36 +429,49 @@ func main() {
 		}
 	}()
 
-	crds := make(chan CrdChange)
-	if err := streamCrds(ctx.Done(), crdclientset.NewForConfigOrDie(localConfig), crds); err != nil {
-		log.Fatalf("Unable to stream CRDs from local Kubernetes: %v", err)
+	if !*leaderElect {
+		runSyncers(ctx, localConfig, local, remote)
+		return
 	}
-	syncers := make(map[string]*crSyncer)
-	for crd := range crds {
-		name := crd.CRD.GetName()
 
-		if cur, ok := syncers[name]; ok {
-			if crd.Type == watch.Added {
-				log.Printf("Warning: Already had a running sync for freshly added %s", name)
-			}
-			cur.stop()
-			delete(syncers, name)
-		}
-		if crd.Type == watch.Added || crd.Type == watch.Modified {
-			// The modify procedure is very heavyweight: We throw away
-			// the informer for the CRD (read: all cached data) on every
-			// modification and recreate it. If that ever turns out to
-			// be a problem, we should use a shared informer cache
-			// instead.
-			s, err := newCRSyncer(ctx, *crd.CRD, local, remote, *robotName)
-			if err != nil {
-				log.Printf("skipping custom resource %s: %s", name, err)
-				continue
-			}
-			syncers[name] = s
-			go s.run()
-		}
+	kubeClient, err := kubernetes.NewForConfig(localConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	identity := leaderElectionIdentity()
+
+	// leaderCtx is the parent context for all syncers started while we hold
+	// the lease. It is cancelled in OnStoppedLeading so a replica that loses
+	// leadership releases its watches instead of leaving them running
+	// alongside the new leader's.
+	leaderCtx, leaderCancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+
+	elector, err := newLeaderElector(kubeClient, identity,
+		func(context.Context) {
+			log.Printf("Acquired leadership as %s, starting syncers", identity)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runSyncers(leaderCtx, localConfig, local, remote)
+			}()
+		},
+		func() {
+			log.Printf("Lost leadership as %s, stopping syncers", identity)
+			leaderCancel()
+			wg.Wait()
+			// Losing a held lease (e.g. a transient renew failure) is treated
+			// the same as never acquiring it: like kube-scheduler and
+			// kube-controller-manager, we exit so the container is restarted
+			// and rejoins the candidate pool as a fresh process, rather than
+			// limping on as a permanently-inactive replica.
+			log.Fatal("Exiting after losing leader election")
+		},
+	)
+	if err != nil {
+		log.Fatal(err)
 	}
+	elector.Run(ctx)
 }
 
 func mustNewTagKey(s string) tag.Key {
@@ -0,0 +1,121 @@
+// Copyright 2019 The Cloud Robotics Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_leaderElectionIdentity(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("os.Hostname: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		podName string
+		want    string
+	}{
+		{
+			name:    "POD_NAME set is preferred over the hostname",
+			podName: "cr-syncer-abc123",
+			want:    "cr-syncer-abc123",
+		},
+		{
+			name:    "POD_NAME unset falls back to the hostname",
+			podName: "",
+			want:    hostname,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("POD_NAME", tt.podName)
+			if got := leaderElectionIdentity(); got != tt.want {
+				t.Fatalf("leaderElectionIdentity() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func writeEgressSelectorConfig(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "egress-selector-config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("writing egress selector config: %v", err)
+	}
+	return path
+}
+
+func Test_dialerForEgressSelector(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantNil bool
+		wantErr bool
+	}{
+		{
+			name: "direct backend returns nil dial func",
+			yaml: `
+apiVersion: apiserver.k8s.io/v1beta1
+kind: EgressSelectorConfiguration
+egressSelections:
+- name: cluster
+  connection:
+    proxyProtocol: Direct
+`,
+			wantNil: true,
+		},
+		{
+			name: "no selection for the cluster egress type is an error",
+			yaml: `
+apiVersion: apiserver.k8s.io/v1beta1
+kind: EgressSelectorConfiguration
+egressSelections:
+- name: master
+  connection:
+    proxyProtocol: Direct
+`,
+			wantErr: true,
+		},
+		{
+			name:    "unparsable config is an error",
+			yaml:    "not: valid: egress: selector: config",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeEgressSelectorConfig(t, tt.yaml)
+			dial, err := dialerForEgressSelector(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantNil && dial != nil {
+				t.Fatal("expected a nil dial func for the direct backend")
+			}
+		})
+	}
+}
@@ -0,0 +1,652 @@
+// Copyright 2019 The Cloud Robotics Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	crdtypes "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+
+	"golang.org/x/net/context"
+)
+
+// See the package doc comment for the semantics of these annotations.
+const (
+	annotationFilterByRobotName = "cr-syncer.cloudrobotics.com/filter-by-robot-name"
+	annotationStatusSubtree     = "cr-syncer.cloudrobotics.com/status-subtree"
+	annotationSpecSource        = "cr-syncer.cloudrobotics.com/spec-source"
+
+	// labelRobotName is the label checked against -robot-name when
+	// filter-by-robot-name is enabled for a CRD.
+	labelRobotName = "cloudrobotics.com/robot-name"
+)
+
+var (
+	mLiveInformers = stats.Int64("cr_syncer/live_informers", "Number of dynamic informers currently shared across syncers", "1")
+	mInformerHits  = stats.Int64("cr_syncer/informer_cache_hits", "CRD modifications that reused an already-running informer", "1")
+	mInformerMiss  = stats.Int64("cr_syncer/informer_cache_rebuilds", "CRD modifications that required creating a new informer", "1")
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Name:        mLiveInformers.Name(),
+			Description: mLiveInformers.Description(),
+			Measure:     mLiveInformers,
+			TagKeys:     []tag.Key{tagLocation},
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Name:        mInformerHits.Name(),
+			Description: mInformerHits.Description(),
+			Measure:     mInformerHits,
+			TagKeys:     []tag.Key{tagLocation},
+			Aggregation: view.Count(),
+		},
+		&view.View{
+			Name:        mInformerMiss.Name(),
+			Description: mInformerMiss.Description(),
+			Measure:     mInformerMiss,
+			TagKeys:     []tag.Key{tagLocation},
+			Aggregation: view.Count(),
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+func recordInformerStat(location string, m *stats.Int64Measure, v int64) {
+	ctx, err := tag.New(context.Background(), tag.Insert(tagLocation, location))
+	if err != nil {
+		klog.Errorf("tagging %s metric: %v", m.Name(), err)
+		return
+	}
+	stats.Record(ctx, m.M(v))
+}
+
+// informerCache lazily creates and shares the dynamic informer for each GVR
+// in a cluster across CRD modifications, so that an annotation-only change
+// to a CRD no longer throws away its informer's watch and cache. It is
+// backed by a single dynamicinformer.DynamicSharedInformerFactory per
+// cluster (local or remote).
+type informerCache struct {
+	factory  dynamicinformer.DynamicSharedInformerFactory
+	location string // "local" or "remote"; used as an OpenCensus tag.
+	done     <-chan struct{}
+
+	mu      sync.Mutex
+	entries map[schema.GroupVersionResource]*informerCacheEntry
+}
+
+type informerCacheEntry struct {
+	// mu guards creating the informer for this one GVR: it is held while
+	// starting the factory and waiting for the new informer's cache to
+	// sync, so that a slow first sync for one GVR never blocks acquire or
+	// release calls for any other GVR (see the comment on acquire).
+	mu       sync.Mutex
+	informer cache.SharedIndexInformer
+	refs     int
+}
+
+// newInformerCache creates the process-wide informer cache for one cluster.
+// done should be the lifetime of the leader's term: informers are started
+// against it and, because client-go's shared informer factories cannot
+// forget a single resource, they keep running harmlessly until done is
+// closed even if every crSyncer using them has released its reference.
+//
+// The factory is filtered to *namespace: localResource/remoteResource only
+// ever write into that namespace, so watching every namespace would let a
+// same-named object elsewhere in the cluster be mirrored in and overwrite it.
+func newInformerCache(client dynamic.Interface, location string, done <-chan struct{}) *informerCache {
+	return &informerCache{
+		factory:  dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, resyncPeriod, *namespace, nil),
+		location: location,
+		done:     done,
+		entries:  make(map[schema.GroupVersionResource]*informerCacheEntry),
+	}
+}
+
+// acquire returns the shared informer for gvr, creating and starting it on
+// first use. Every call must be matched by a call to release once the
+// caller no longer needs the informer.
+//
+// c.mu only ever guards the entries map itself, never the informer's
+// startup: with hundreds of CRDs arriving at once, each wanting a distinct
+// GVR, holding the cache-wide lock across factory.Start and
+// WaitForCacheSync would serialize every unrelated acquire/release behind
+// whichever GVR happens to be syncing, reintroducing the startup stall this
+// cache exists to avoid. Instead each entry has its own mutex, so a slow
+// first sync for one GVR only blocks concurrent acquires of that same GVR.
+func (c *informerCache) acquire(gvr schema.GroupVersionResource) (cache.SharedIndexInformer, error) {
+	c.mu.Lock()
+	e, ok := c.entries[gvr]
+	if !ok {
+		e = &informerCacheEntry{}
+		c.entries[gvr] = e
+	}
+	c.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.informer != nil {
+		e.refs++
+		recordInformerStat(c.location, mInformerHits, 1)
+		return e.informer, nil
+	}
+
+	informer := c.factory.ForResource(gvr).Informer()
+	c.factory.Start(c.done)
+	if !cache.WaitForCacheSync(c.done, informer.HasSynced) {
+		c.mu.Lock()
+		delete(c.entries, gvr)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("syncing informer cache for %s failed", gvr)
+	}
+	e.informer = informer
+	e.refs = 1
+	recordInformerStat(c.location, mInformerMiss, 1)
+
+	c.mu.Lock()
+	recordInformerStat(c.location, mLiveInformers, int64(len(c.entries)))
+	c.mu.Unlock()
+	return informer, nil
+}
+
+// release drops a reference to the informer for gvr. The underlying
+// informer is only actually torn down by the process losing leadership (see
+// newInformerCache); this just stops this package from tracking it as live
+// so a later re-add of the same GVR is reflected in the metrics.
+func (c *informerCache) release(gvr schema.GroupVersionResource) {
+	// c.mu is held for the whole call, unlike in acquire, so that a
+	// concurrent acquire for the same gvr can never re-grab and start
+	// using the entry in the window between this call deciding to drop
+	// it and actually removing it from the map.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[gvr]
+	if !ok {
+		return
+	}
+
+	e.mu.Lock()
+	e.refs--
+	drop := e.refs <= 0
+	e.mu.Unlock()
+	if !drop {
+		return
+	}
+
+	delete(c.entries, gvr)
+	recordInformerStat(c.location, mLiveInformers, int64(len(c.entries)))
+}
+
+// gvrForCRD returns the GroupVersionResource of the CRD's storage version.
+func gvrForCRD(crd *crdtypes.CustomResourceDefinition) (schema.GroupVersionResource, error) {
+	for _, v := range crd.Spec.Versions {
+		if v.Storage {
+			return schema.GroupVersionResource{
+				Group:    crd.Spec.Group,
+				Version:  v.Name,
+				Resource: crd.Spec.Names.Plural,
+			}, nil
+		}
+	}
+	return schema.GroupVersionResource{}, fmt.Errorf("CRD %s has no storage version", crd.GetName())
+}
+
+// crSyncer syncs a single CRD's custom resources between the local and
+// remote cluster, as configured by the annotations on the CRD (see the
+// package doc comment). Its informers are owned by the process-wide
+// informerCache for each cluster and outlive any single crSyncer: a CRD
+// modification that only changes annotations calls reconfigure instead of
+// recreating the syncer.
+//
+// Event handlers only enqueue the changed object's cache key onto
+// localQueue/remoteQueue; the actual propagation runs on the worker
+// goroutines started by run, re-reading the object's latest state from the
+// informer's indexer instead of the stale snapshot the event carried, so a
+// slow or conflicting write to one cluster never blocks delivery of other
+// events for this GVR, a failed sync is retried with backoff instead of
+// being dropped until the next resync, and a retried sync can never clobber
+// a newer event (including a delete) for the same key that already landed.
+type crSyncer struct {
+	ctx           context.Context
+	name          string
+	gvr           schema.GroupVersionResource
+	local, remote dynamic.Interface
+	localCache    *informerCache
+	remoteCache   *informerCache
+	robotName     string
+	localQueue    workqueue.RateLimitingInterface
+	remoteQueue   workqueue.RateLimitingInterface
+
+	mu                sync.Mutex
+	filterByRobotName bool
+	statusSubtree     string
+	specSource        string
+	localInformer     cache.SharedIndexInformer
+	remoteInformer    cache.SharedIndexInformer
+	localReg          cache.ResourceEventHandlerRegistration
+	remoteReg         cache.ResourceEventHandlerRegistration
+}
+
+func newCRSyncer(
+	ctx context.Context,
+	crd crdtypes.CustomResourceDefinition,
+	localCache, remoteCache *informerCache,
+	local, remote dynamic.Interface,
+	robotName string,
+) (*crSyncer, error) {
+	gvr, err := gvrForCRD(&crd)
+	if err != nil {
+		return nil, err
+	}
+	localInformer, err := localCache.acquire(gvr)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring local informer for %s: %v", crd.GetName(), err)
+	}
+	remoteInformer, err := remoteCache.acquire(gvr)
+	if err != nil {
+		localCache.release(gvr)
+		return nil, fmt.Errorf("acquiring remote informer for %s: %v", crd.GetName(), err)
+	}
+
+	s := &crSyncer{
+		ctx:            ctx,
+		name:           crd.GetName(),
+		gvr:            gvr,
+		local:          local,
+		remote:         remote,
+		localCache:     localCache,
+		remoteCache:    remoteCache,
+		robotName:      robotName,
+		localInformer:  localInformer,
+		remoteInformer: remoteInformer,
+		localQueue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), crd.GetName()+"-local"),
+		remoteQueue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), crd.GetName()+"-remote"),
+	}
+	if err := s.reconfigure(crd); err != nil {
+		s.stop()
+		return nil, err
+	}
+	return s, nil
+}
+
+// reconfigure re-derives the syncer's annotation-driven config from crd and
+// swaps its event handlers accordingly, without dropping the underlying
+// informers or their caches. It is used both for the syncer's initial setup
+// and for CRD modifications that keep the same GroupVersionResource; the
+// latter is the common case the informer cache exists for, so it is counted
+// as a cache hit alongside the hits acquire records for distinct CRDs
+// sharing a GVR.
+func (s *crSyncer) reconfigure(crd crdtypes.CustomResourceDefinition) error {
+	ann := crd.GetAnnotations()
+	filterByRobotName, _ := strconv.ParseBool(ann[annotationFilterByRobotName])
+	statusSubtree := ann[annotationStatusSubtree]
+	specSource := ann[annotationSpecSource]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reused := s.localReg != nil
+	s.filterByRobotName = filterByRobotName
+	s.statusSubtree = statusSubtree
+	s.specSource = specSource
+
+	if s.localReg != nil {
+		if err := s.localInformer.RemoveEventHandler(s.localReg); err != nil {
+			return fmt.Errorf("removing local event handler for %s: %v", s.name, err)
+		}
+	}
+	if s.remoteReg != nil {
+		if err := s.remoteInformer.RemoveEventHandler(s.remoteReg); err != nil {
+			return fmt.Errorf("removing remote event handler for %s: %v", s.name, err)
+		}
+	}
+
+	localReg, err := s.localInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { s.enqueue(s.localQueue, obj) },
+		UpdateFunc: func(_, obj interface{}) { s.enqueue(s.localQueue, obj) },
+		DeleteFunc: func(obj interface{}) { s.enqueue(s.localQueue, obj) },
+	})
+	if err != nil {
+		return fmt.Errorf("adding local event handler for %s: %v", s.name, err)
+	}
+	remoteReg, err := s.remoteInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { s.enqueue(s.remoteQueue, obj) },
+		UpdateFunc: func(_, obj interface{}) { s.enqueue(s.remoteQueue, obj) },
+		DeleteFunc: func(obj interface{}) { s.enqueue(s.remoteQueue, obj) },
+	})
+	if err != nil {
+		return fmt.Errorf("adding remote event handler for %s: %v", s.name, err)
+	}
+	s.localReg, s.remoteReg = localReg, remoteReg
+
+	if reused {
+		recordInformerStat(s.localCache.location, mInformerHits, 1)
+		recordInformerStat(s.remoteCache.location, mInformerHits, 1)
+	}
+	return nil
+}
+
+// enqueue computes the cache key for obj, as delivered to an informer event
+// handler (unwrapping a DeletedFinalStateUnknown tombstone if necessary),
+// and adds it to queue. It is the only thing event handlers do: the actual
+// sync runs later on a worker goroutine, which re-reads the object's current
+// state from the informer's indexer rather than acting on this event's
+// payload, so handler delivery for this GVR is never held up by a slow or
+// retried API call, and a retry can never act on a stale snapshot.
+func (s *crSyncer) enqueue(queue workqueue.RateLimitingInterface, obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		klog.Errorf("%s: computing cache key for %T: %v", s.name, obj, err)
+		return
+	}
+	queue.Add(key)
+}
+
+// syncLocal reacts to a change of a local (downstream) object named by key:
+// when spec-source is "robot" this cluster is the source of truth for the
+// spec, so the change (including deletion) is propagated to the remote
+// cluster. When spec-source is "cloud", local is instead the status source
+// and its status is propagated upstream to remote.
+func (s *crSyncer) syncLocal(key string) error {
+	s.mu.Lock()
+	specSource, filterByRobotName, statusSubtree := s.specSource, s.filterByRobotName, s.statusSubtree
+	s.mu.Unlock()
+
+	u, exists, err := lookupKey(s.localInformer, key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if specSource != "robot" {
+			return nil
+		}
+		_, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			return fmt.Errorf("splitting key %s: %v", key, err)
+		}
+		if err := deleteResource(s.ctx, name, s.remoteResource()); err != nil {
+			return fmt.Errorf("propagating deletion of %s from local to remote: %v", name, err)
+		}
+		return nil
+	}
+	if filterByRobotName && !s.matchesRobot(u) {
+		return nil
+	}
+
+	switch specSource {
+	case "robot":
+		if err := propagateSpec(s.ctx, u, s.remoteResource()); err != nil {
+			return fmt.Errorf("propagating spec of %s from local to remote: %v", u.GetName(), err)
+		}
+	case "cloud":
+		if err := propagateStatus(s.ctx, u, statusSubtree, s.remoteResource()); err != nil {
+			return fmt.Errorf("propagating status of %s from local to remote: %v", u.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// syncRemote reacts to a change of a remote (upstream) object named by key:
+// when spec-source is "cloud" this cluster is the source of truth for the
+// spec, so the change (including deletion) is propagated to the local
+// cluster. When spec-source is "robot", remote is instead the status source
+// and its status is propagated downstream to local.
+func (s *crSyncer) syncRemote(key string) error {
+	s.mu.Lock()
+	specSource, filterByRobotName, statusSubtree := s.specSource, s.filterByRobotName, s.statusSubtree
+	s.mu.Unlock()
+
+	u, exists, err := lookupKey(s.remoteInformer, key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if specSource != "cloud" {
+			return nil
+		}
+		_, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			return fmt.Errorf("splitting key %s: %v", key, err)
+		}
+		if err := deleteResource(s.ctx, name, s.localResource()); err != nil {
+			return fmt.Errorf("propagating deletion of %s from remote to local: %v", name, err)
+		}
+		return nil
+	}
+	if filterByRobotName && !s.matchesRobot(u) {
+		return nil
+	}
+
+	switch specSource {
+	case "cloud":
+		if err := propagateSpec(s.ctx, u, s.localResource()); err != nil {
+			return fmt.Errorf("propagating spec of %s from remote to local: %v", u.GetName(), err)
+		}
+	case "robot":
+		if err := propagateStatus(s.ctx, u, statusSubtree, s.localResource()); err != nil {
+			return fmt.Errorf("propagating status of %s from remote to local: %v", u.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// lookupKey returns the current state of key in informer's cache: ok is
+// false if the object no longer exists there, which a worker treats as a
+// deletion regardless of how long the key sat in the queue.
+func lookupKey(informer cache.SharedIndexInformer, key string) (u *unstructured.Unstructured, ok bool, err error) {
+	obj, exists, err := informer.GetIndexer().GetByKey(key)
+	if err != nil {
+		return nil, false, fmt.Errorf("getting %s from cache: %v", key, err)
+	}
+	if !exists {
+		return nil, false, nil
+	}
+	u, ok = obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, false, fmt.Errorf("cache entry for %s is a %T, not unstructured", key, obj)
+	}
+	return u, true, nil
+}
+
+// runWorker drains queue until it is shut down, calling sync for each key.
+// A key that fails is retried with the queue's exponential backoff up to
+// conflictErrorLimit times (conflictErrorLimit+1 attempts in all), after
+// which it is dropped and the error logged; drift introduced by a dropped key is still fixed by the
+// next resync.
+func (s *crSyncer) runWorker(direction string, queue workqueue.RateLimitingInterface, sync func(string) error) {
+	for {
+		item, shutdown := queue.Get()
+		if shutdown {
+			return
+		}
+		key := item.(string)
+		err := sync(key)
+		if err == nil {
+			queue.Forget(item)
+			queue.Done(item)
+			continue
+		}
+		if queue.NumRequeues(item) >= *conflictErrorLimit {
+			klog.Errorf("%s: giving up syncing %s (%s) after %d attempts: %v", s.name, key, direction, queue.NumRequeues(item)+1, err)
+			queue.Forget(item)
+			queue.Done(item)
+			continue
+		}
+		klog.Warningf("%s: retrying sync of %s (%s): %v", s.name, key, direction, err)
+		queue.AddRateLimited(item)
+		queue.Done(item)
+	}
+}
+
+// localResource returns the namespaced dynamic client for this syncer's GVR
+// in the local cluster.
+func (s *crSyncer) localResource() dynamic.ResourceInterface {
+	return s.local.Resource(s.gvr).Namespace(*namespace)
+}
+
+// remoteResource returns the namespaced dynamic client for this syncer's GVR
+// in the remote cluster.
+func (s *crSyncer) remoteResource() dynamic.ResourceInterface {
+	return s.remote.Resource(s.gvr).Namespace(*namespace)
+}
+
+// matchesRobot reports whether u carries the robot-name label matching this
+// syncer's -robot-name, as required by the filter-by-robot-name annotation.
+func (s *crSyncer) matchesRobot(u *unstructured.Unstructured) bool {
+	return u.GetLabels()[labelRobotName] == s.robotName
+}
+
+// deleteResource deletes the destination object named name, e.g. the
+// local/remote mirror of a spec source that was just deleted. It is a no-op
+// if the destination is already gone.
+func deleteResource(ctx context.Context, name string, dst dynamic.ResourceInterface) error {
+	if err := dst.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleting %s: %v", name, err)
+	}
+	return nil
+}
+
+// propagateSpec mirrors src's existence, spec, labels and annotations to
+// dst: a new source is created (without its status, which the other sync
+// direction owns), and an existing destination has its spec, labels and
+// annotations overwritten in place. Deletion is handled separately by
+// deleteResource, since by the time a deletion is processed src is already
+// gone from the informer cache.
+func propagateSpec(ctx context.Context, src *unstructured.Unstructured, dst dynamic.ResourceInterface) error {
+	cur, err := dst.Get(ctx, src.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		out := src.DeepCopy()
+		out.SetResourceVersion("")
+		out.SetUID("")
+		out.SetSelfLink("")
+		out.SetManagedFields(nil)
+		unstructured.RemoveNestedField(out.Object, "status")
+		if _, err := dst.Create(ctx, out, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("creating %s: %v", src.GetName(), err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("getting %s: %v", src.GetName(), err)
+	}
+
+	spec, found, err := unstructured.NestedFieldCopy(src.Object, "spec")
+	if err != nil {
+		return fmt.Errorf("reading spec of %s: %v", src.GetName(), err)
+	}
+	if found {
+		if err := unstructured.SetNestedField(cur.Object, spec, "spec"); err != nil {
+			return fmt.Errorf("setting spec of %s: %v", src.GetName(), err)
+		}
+	}
+	cur.SetLabels(src.GetLabels())
+	cur.SetAnnotations(src.GetAnnotations())
+	if _, err := dst.Update(ctx, cur, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("updating %s: %v", src.GetName(), err)
+	}
+	return nil
+}
+
+// propagateStatus copies src's status (or, if subtree is set, just that
+// subtree of it) onto the destination object of the same name. It is a
+// no-op if the destination doesn't exist yet, which happens when the
+// spec-copy direction hasn't created it.
+func propagateStatus(ctx context.Context, src *unstructured.Unstructured, subtree string, dst dynamic.ResourceInterface) error {
+	cur, err := dst.Get(ctx, src.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("getting %s: %v", src.GetName(), err)
+	}
+
+	path := []string{"status"}
+	if subtree != "" {
+		path = append(path, subtree)
+	}
+	val, found, err := unstructured.NestedFieldCopy(src.Object, path...)
+	if err != nil {
+		return fmt.Errorf("reading status of %s: %v", src.GetName(), err)
+	}
+	if !found {
+		return nil
+	}
+	if err := unstructured.SetNestedField(cur.Object, val, path...); err != nil {
+		return fmt.Errorf("setting status of %s: %v", src.GetName(), err)
+	}
+	if _, err := dst.UpdateStatus(ctx, cur, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("updating status of %s: %v", src.GetName(), err)
+	}
+	return nil
+}
+
+// run starts the syncer's workqueue workers and blocks until both queues are
+// shut down by stop.
+func (s *crSyncer) run() {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.runWorker("local-to-remote", s.localQueue, s.syncLocal)
+	}()
+	go func() {
+		defer wg.Done()
+		s.runWorker("remote-to-local", s.remoteQueue, s.syncRemote)
+	}()
+	wg.Wait()
+}
+
+// stop releases the syncer's references to its shared informers and shuts
+// down its workqueues, which unblocks run. The informers themselves keep
+// running even after every syncer using their GVR has released it, until the
+// informer cache's done channel is closed when the process loses leadership
+// (see newInformerCache).
+func (s *crSyncer) stop() {
+	s.mu.Lock()
+	if s.localReg != nil {
+		s.localInformer.RemoveEventHandler(s.localReg)
+		s.localReg = nil
+	}
+	if s.remoteReg != nil {
+		s.remoteInformer.RemoveEventHandler(s.remoteReg)
+		s.remoteReg = nil
+	}
+	s.mu.Unlock()
+
+	s.localQueue.ShutDown()
+	s.remoteQueue.ShutDown()
+
+	s.localCache.release(s.gvr)
+	s.remoteCache.release(s.gvr)
+}
@@ -0,0 +1,385 @@
+// Copyright 2019 The Cloud Robotics Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opencensus.io/stats/view"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"golang.org/x/net/context"
+)
+
+var fakeGVR = schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+
+func newWidget(namespace, name string, fields map[string]interface{}) *unstructured.Unstructured {
+	obj := map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "Widget",
+		"metadata": map[string]interface{}{
+			"namespace": namespace,
+			"name":      name,
+		},
+	}
+	for k, v := range fields {
+		obj[k] = v
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func Test_enqueue_usesNamespaceNameKey(t *testing.T) {
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+
+	s := &crSyncer{name: "widgets"}
+	s.enqueue(queue, newWidget("default", "foo", nil))
+
+	item, _ := queue.Get()
+	if item != "default/foo" {
+		t.Fatalf("queue item = %q, want %q", item, "default/foo")
+	}
+}
+
+func Test_enqueue_unwrapsTombstone(t *testing.T) {
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+
+	s := &crSyncer{name: "widgets"}
+	tombstone := cache.DeletedFinalStateUnknown{
+		Key: "default/foo",
+		Obj: newWidget("default", "foo", nil),
+	}
+	s.enqueue(queue, tombstone)
+
+	item, _ := queue.Get()
+	if item != "default/foo" {
+		t.Fatalf("queue item = %q, want %q", item, "default/foo")
+	}
+}
+
+func Test_lookupKey(t *testing.T) {
+	informer := newFakeInformer(t, newWidget("default", "foo", nil))
+
+	u, ok, err := lookupKey(informer, "default/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("lookupKey: want ok = true for an object in the cache")
+	}
+	if u.GetName() != "foo" {
+		t.Fatalf("lookupKey: name = %q, want %q", u.GetName(), "foo")
+	}
+
+	_, ok, err = lookupKey(informer, "default/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("lookupKey: want ok = false for a key not in the cache")
+	}
+}
+
+// newFakeInformer returns a SharedIndexInformer whose indexer is pre-seeded
+// with objs, without running the informer's watch loop. This is enough to
+// exercise lookupKey, which only ever reads the indexer.
+func newFakeInformer(t *testing.T, objs ...runtime.Object) cache.SharedIndexInformer {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.DeletionHandlingMetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, obj := range objs {
+		if err := indexer.Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &fakeIndexerInformer{indexer: indexer}
+}
+
+// fakeIndexerInformer implements just enough of cache.SharedIndexInformer
+// for lookupKey: a pre-populated indexer. The other methods are unused by
+// the code under test.
+type fakeIndexerInformer struct {
+	cache.SharedIndexInformer
+	indexer cache.Indexer
+}
+
+func (f *fakeIndexerInformer) GetIndexer() cache.Indexer { return f.indexer }
+
+func Test_runWorker_retriesThenGivesUp(t *testing.T) {
+	oldLimit := conflictErrorLimit
+	limit := 2
+	conflictErrorLimit = &limit
+	defer func() { conflictErrorLimit = oldLimit }()
+
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	queue.Add("default/foo")
+
+	var attempts int
+	s := &crSyncer{name: "widgets"}
+	done := make(chan struct{})
+	go func() {
+		s.runWorker("local-to-remote", queue, func(key string) error {
+			attempts++
+			if attempts > limit {
+				queue.ShutDown()
+			}
+			return errors.New("conflict")
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runWorker did not return after queue.ShutDown")
+	}
+	if attempts <= limit {
+		t.Fatalf("attempts = %d, want more than %d before giving up", attempts, limit)
+	}
+	if n := queue.NumRequeues("default/foo"); n != 0 {
+		t.Fatalf("NumRequeues after giving up = %d, want 0 (key forgotten rather than requeued)", n)
+	}
+}
+
+func Test_deleteResource_notFoundIsNoop(t *testing.T) {
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	dst := client.Resource(fakeGVR).Namespace("default")
+
+	if err := deleteResource(context.Background(), "missing", dst); err != nil {
+		t.Fatalf("deleteResource on a missing object: %v", err)
+	}
+}
+
+func Test_propagateSpec_createsMissingDestination(t *testing.T) {
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	dst := client.Resource(fakeGVR).Namespace("default")
+
+	src := newWidget("default", "foo", map[string]interface{}{
+		"spec":   map[string]interface{}{"size": "large"},
+		"status": map[string]interface{}{"ready": true},
+	})
+	if err := propagateSpec(context.Background(), src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dst.Get(context.Background(), "foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := unstructured.NestedString(got.Object, "status", "ready"); found {
+		t.Fatal("propagateSpec: created object should not carry src's status")
+	}
+	if size, _, _ := unstructured.NestedString(got.Object, "spec", "size"); size != "large" {
+		t.Fatalf("spec.size = %q, want %q", size, "large")
+	}
+}
+
+func Test_propagateSpec_updatesExistingDestination(t *testing.T) {
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	dst := client.Resource(fakeGVR).Namespace("default")
+
+	existing := newWidget("default", "foo", map[string]interface{}{
+		"spec":   map[string]interface{}{"size": "small"},
+		"status": map[string]interface{}{"ready": true},
+	})
+	if _, err := dst.Create(context.Background(), existing, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	src := newWidget("default", "foo", map[string]interface{}{
+		"spec": map[string]interface{}{"size": "large"},
+	})
+	if err := propagateSpec(context.Background(), src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dst.Get(context.Background(), "foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, _, _ := unstructured.NestedString(got.Object, "spec", "size"); size != "large" {
+		t.Fatalf("spec.size = %q, want %q", size, "large")
+	}
+	if ready, _, _ := unstructured.NestedBool(got.Object, "status", "ready"); !ready {
+		t.Fatal("propagateSpec: update must not touch the destination's status")
+	}
+}
+
+func Test_propagateStatus_noopIfDestinationMissing(t *testing.T) {
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	dst := client.Resource(fakeGVR).Namespace("default")
+
+	src := newWidget("default", "foo", map[string]interface{}{
+		"status": map[string]interface{}{"ready": true},
+	})
+	if err := propagateStatus(context.Background(), src, "", dst); err != nil {
+		t.Fatalf("propagateStatus with no destination: %v", err)
+	}
+	if _, err := dst.Get(context.Background(), "foo", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("propagateStatus must not create the destination, got err = %v", err)
+	}
+}
+
+func Test_propagateStatus_copiesSubtree(t *testing.T) {
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	dst := client.Resource(fakeGVR).Namespace("default")
+
+	existing := newWidget("default", "foo", map[string]interface{}{
+		"status": map[string]interface{}{"health": map[string]interface{}{"ok": false}},
+	})
+	if _, err := dst.Create(context.Background(), existing, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	src := newWidget("default", "foo", map[string]interface{}{
+		"status": map[string]interface{}{"health": map[string]interface{}{"ok": true}, "other": "ignored"},
+	})
+	if err := propagateStatus(context.Background(), src, "health", dst); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dst.Get(context.Background(), "foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, _ := unstructured.NestedBool(got.Object, "status", "health", "ok"); !ok {
+		t.Fatal("propagateStatus did not copy the requested subtree")
+	}
+	if _, found, _ := unstructured.NestedString(got.Object, "status", "other"); found {
+		t.Fatal("propagateStatus copied a field outside the requested subtree")
+	}
+}
+
+// informerStatCount returns the current count recorded against location for
+// the named OpenCensus count view (mInformerHits/mInformerMiss). location
+// should be unique per test so counts from other tests in this package
+// don't bleed into the assertion.
+func informerStatCount(t *testing.T, viewName, location string) int64 {
+	t.Helper()
+	rows, err := view.RetrieveData(viewName)
+	if err != nil {
+		t.Fatalf("retrieving view %s: %v", viewName, err)
+	}
+	var total int64
+	for _, row := range rows {
+		for _, tg := range row.Tags {
+			if tg.Key == tagLocation && tg.Value == location {
+				if data, ok := row.Data.(*view.CountData); ok {
+					total += data.Value
+				}
+			}
+		}
+	}
+	return total
+}
+
+func Test_informerCache_acquireReleaseRefcountsAndRecordsHitsAndMisses(t *testing.T) {
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	done := make(chan struct{})
+	defer close(done)
+	const location = "test-acquire-release"
+	c := newInformerCache(client, location, done)
+
+	informer, err := c.acquire(fakeGVR)
+	if err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	if informer == nil {
+		t.Fatal("expected a non-nil informer")
+	}
+	if got := informerStatCount(t, mInformerMiss.Name(), location); got != 1 {
+		t.Fatalf("misses after first acquire = %d, want 1", got)
+	}
+	if got := informerStatCount(t, mInformerHits.Name(), location); got != 0 {
+		t.Fatalf("hits after first acquire = %d, want 0", got)
+	}
+
+	again, err := c.acquire(fakeGVR)
+	if err != nil {
+		t.Fatalf("second acquire: %v", err)
+	}
+	if again != informer {
+		t.Fatal("a second acquire of the same GVR should return the already-running shared informer")
+	}
+	if got := informerStatCount(t, mInformerHits.Name(), location); got != 1 {
+		t.Fatalf("hits after second acquire = %d, want 1", got)
+	}
+	if len(c.entries) != 1 {
+		t.Fatalf("entries = %d, want 1 while both references are held", len(c.entries))
+	}
+
+	c.release(fakeGVR)
+	if len(c.entries) != 1 {
+		t.Fatal("releasing one of two references should not drop the entry")
+	}
+	c.release(fakeGVR)
+	if len(c.entries) != 0 {
+		t.Fatal("releasing the last reference should drop the entry")
+	}
+
+	// Releasing a GVR that was never acquired, or one already fully
+	// released, must be a no-op rather than panicking.
+	c.release(fakeGVR)
+}
+
+func Test_informerCache_concurrentAcquireOfSameGVR(t *testing.T) {
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	done := make(chan struct{})
+	defer close(done)
+	c := newInformerCache(client, "test-concurrent-acquire", done)
+
+	const n = 20
+	informers := make([]cache.SharedIndexInformer, n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			inf, err := c.acquire(fakeGVR)
+			if err != nil {
+				t.Errorf("acquire %d: %v", i, err)
+				return
+			}
+			informers[i] = inf
+		}(i)
+	}
+	wg.Wait()
+
+	for i, inf := range informers {
+		if inf == nil || inf != informers[0] {
+			t.Fatalf("acquire %d returned a different informer than acquire 0, want every concurrent acquire of the same GVR to share it", i)
+		}
+	}
+	if len(c.entries) != 1 {
+		t.Fatalf("entries = %d, want 1", len(c.entries))
+	}
+
+	for i := 0; i < n; i++ {
+		c.release(fakeGVR)
+	}
+	if len(c.entries) != 0 {
+		t.Fatal("releasing every acquired reference should drop the entry")
+	}
+}
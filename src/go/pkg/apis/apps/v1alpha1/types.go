@@ -0,0 +1,333 @@
+// Copyright 2019 The Cloud Robotics Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package v1alpha1 contains the types for the apps.cloudrobotics.com/v1alpha1
+// API group, in particular the ChartAssignment CRD through which the cloud
+// and robot clusters are told which Helm charts to install.
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// SchemeGroupVersion is the group version used to register these types.
+var SchemeGroupVersion = schema.GroupVersion{Group: "apps.cloudrobotics.com", Version: "v1alpha1"}
+
+// Resource returns a GroupResource for the given resource name in this API group.
+func Resource(resource string) schema.GroupResource {
+	return SchemeGroupVersion.WithResource(resource).GroupResource()
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ChartAssignment assigns a Helm chart to a Kubernetes cluster and tracks
+// the status of its rollout.
+type ChartAssignment struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ChartAssignmentSpec   `json:"spec"`
+	Status ChartAssignmentStatus `json:"status,omitempty"`
+}
+
+// DeepCopyObject implements runtime.Object.
+func (in *ChartAssignment) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartAssignment)
+	*out = *in
+	out.ObjectMeta = *in.ObjectMeta.DeepCopy()
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+	return out
+}
+
+// ChartAssignmentList is a list of ChartAssignments.
+type ChartAssignmentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ChartAssignment `json:"items"`
+}
+
+// DeepCopyObject implements runtime.Object.
+func (in *ChartAssignmentList) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartAssignmentList)
+	*out = *in
+	out.ListMeta = *in.ListMeta.DeepCopy()
+	if in.Items != nil {
+		out.Items = make([]ChartAssignment, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+// DeepCopyInto copies the receiver into out.
+func (in *ChartAssignment) DeepCopyInto(out *ChartAssignment) {
+	*out = *in
+	out.ObjectMeta = *in.ObjectMeta.DeepCopy()
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// ChartAssignmentSpec is the spec of a ChartAssignment.
+type ChartAssignmentSpec struct {
+	// ClusterName is the name of the cluster the chart is assigned to.
+	ClusterName string `json:"clusterName"`
+	// NamespaceName is the namespace into which the chart is installed.
+	NamespaceName string `json:"namespaceName"`
+
+	Chart           ChartSpec       `json:"chart"`
+	RolloutStrategy RolloutStrategy `json:"rolloutStrategy,omitempty"`
+
+	// DryRun, if true, never applies the chart's resources. Instead the
+	// controller computes what would change and stores it in
+	// Status.Preview, like a Helm-style "helm diff upgrade".
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// DeepCopyInto copies the receiver into out.
+func (in *ChartAssignmentSpec) DeepCopyInto(out *ChartAssignmentSpec) {
+	*out = *in
+	in.Chart.DeepCopyInto(&out.Chart)
+	out.RolloutStrategy = in.RolloutStrategy
+}
+
+// ChartSpec describes where to source a Helm chart and how to configure it.
+//
+// Exactly one of Inline, OCI, or Repository should be set to select the
+// chart source; loadChart resolves whichever is present.
+type ChartSpec struct {
+	// Inline is a base64-encoded Helm chart tarball.
+	Inline string `json:"inline,omitempty"`
+	// OCI sources the chart from an OCI registry, e.g.
+	// "oci://registry.example.com/charts/foo:1.2.3".
+	OCI *OCIChartSource `json:"oci,omitempty"`
+	// Repository sources the chart from a classic HTTP Helm chart
+	// repository serving an index.yaml.
+	Repository *RepositoryChartSource `json:"repository,omitempty"`
+
+	// Values holds the Helm values merged on top of the chart's defaults.
+	Values map[string]interface{} `json:"values,omitempty"`
+}
+
+// DeepCopyInto copies the receiver into out.
+func (in *ChartSpec) DeepCopyInto(out *ChartSpec) {
+	*out = *in
+	if in.OCI != nil {
+		out.OCI = new(OCIChartSource)
+		*out.OCI = *in.OCI
+	}
+	if in.Repository != nil {
+		out.Repository = new(RepositoryChartSource)
+		*out.Repository = *in.Repository
+	}
+	if in.Values != nil {
+		out.Values = make(map[string]interface{}, len(in.Values))
+		for k, v := range in.Values {
+			out.Values[k] = v
+		}
+	}
+}
+
+// OCIChartSource pulls a chart from an OCI registry.
+type OCIChartSource struct {
+	// Ref is the OCI reference, e.g. "oci://registry.example.com/charts/foo:1.2.3".
+	Ref string `json:"ref"`
+	// AuthSecretRef names a Secret of type kubernetes.io/dockerconfigjson
+	// in the ChartAssignment's namespace used to authenticate to the
+	// registry. Optional for public registries.
+	AuthSecretRef string `json:"authSecretRef,omitempty"`
+}
+
+// RepositoryChartSource resolves a chart from a classic HTTP Helm chart
+// repository's index.yaml.
+type RepositoryChartSource struct {
+	// Repository is the base URL of the chart repository.
+	Repository string `json:"repository"`
+	// Name is the chart name as listed in the repository's index.yaml.
+	Name string `json:"name"`
+	// Version is a version or semver range (e.g. "^2.1") to resolve
+	// against the versions advertised in index.yaml.
+	Version string `json:"version"`
+	// Provenance, if true, requires and verifies the chart's .prov PGP
+	// signature file alongside the tarball.
+	Provenance bool `json:"provenance,omitempty"`
+}
+
+// RolloutStrategyType selects how a ChartAssignment's resources are applied
+// to the target cluster.
+type RolloutStrategyType string
+
+const (
+	// RolloutStrategyClientSideApply performs a client-side three-way
+	// merge, as synk has always done.
+	RolloutStrategyClientSideApply RolloutStrategyType = "ClientSide"
+	// RolloutStrategyServerSideApply uses the Kubernetes server-side apply
+	// PATCH verb instead.
+	RolloutStrategyServerSideApply RolloutStrategyType = "ServerSide"
+)
+
+// RolloutStrategy configures how a ChartAssignment's resources are applied.
+type RolloutStrategy struct {
+	// ServerSideApply switches the applier from the default client-side
+	// three-way merge to a server-side apply PATCH.
+	ServerSideApply bool `json:"serverSideApply,omitempty"`
+	// Force allows a server-side apply to take ownership of fields
+	// currently managed by another field manager.
+	Force bool `json:"force,omitempty"`
+}
+
+// ChartAssignmentStatus is the observed state of a ChartAssignment.
+type ChartAssignmentStatus struct {
+	Phase      ChartAssignmentPhase `json:"phase,omitempty"`
+	Conditions []Condition          `json:"conditions,omitempty"`
+	// Conflicts lists the fields a server-side apply could not take
+	// ownership of the last time ConditionApplyConflict was set. It is
+	// cleared on the next successful apply.
+	Conflicts []FieldConflict `json:"conflicts,omitempty"`
+	// Preview holds the structured diff computed for a Spec.DryRun
+	// ChartAssignment instead of applying anything. It is cleared as soon
+	// as DryRun is unset and the chart is applied for real.
+	Preview *Preview `json:"preview,omitempty"`
+}
+
+// DeepCopyInto copies the receiver into out.
+func (in *ChartAssignmentStatus) DeepCopyInto(out *ChartAssignmentStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		out.Conditions = make([]Condition, len(in.Conditions))
+		copy(out.Conditions, in.Conditions)
+	}
+	if in.Conflicts != nil {
+		out.Conflicts = make([]FieldConflict, len(in.Conflicts))
+		copy(out.Conflicts, in.Conflicts)
+	}
+	if in.Preview != nil {
+		out.Preview = new(Preview)
+		in.Preview.DeepCopyInto(out.Preview)
+	}
+}
+
+// FieldConflict describes one field that a server-side apply could not take
+// ownership of without Spec.RolloutStrategy.Force.
+type FieldConflict struct {
+	FieldPath string `json:"fieldPath"`
+	Manager   string `json:"manager"`
+}
+
+// ObjectRef identifies a single object rendered from a ChartAssignment's
+// chart.
+type ObjectRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// ObjectPatch is the JSON patch that would bring an existing object in line
+// with the chart's rendered state.
+type ObjectPatch struct {
+	ObjectRef `json:",inline"`
+	Patch     string `json:"patch"`
+}
+
+// Preview is the structured diff computed for a Spec.DryRun ChartAssignment
+// in place of applying it. Added and Removed list whole objects; Changed
+// lists the per-object JSON patch that an apply would send.
+type Preview struct {
+	Added   []ObjectRef   `json:"added,omitempty"`
+	Changed []ObjectPatch `json:"changed,omitempty"`
+	Removed []ObjectRef   `json:"removed,omitempty"`
+}
+
+// DeepCopyInto copies the receiver into out.
+func (in *Preview) DeepCopyInto(out *Preview) {
+	*out = *in
+	if in.Added != nil {
+		out.Added = make([]ObjectRef, len(in.Added))
+		copy(out.Added, in.Added)
+	}
+	if in.Changed != nil {
+		out.Changed = make([]ObjectPatch, len(in.Changed))
+		copy(out.Changed, in.Changed)
+	}
+	if in.Removed != nil {
+		out.Removed = make([]ObjectRef, len(in.Removed))
+		copy(out.Removed, in.Removed)
+	}
+}
+
+// ChartAssignmentPhase is a high-level summary of a ChartAssignment's state.
+type ChartAssignmentPhase string
+
+const (
+	ChartAssignmentPhasePending  ChartAssignmentPhase = "Pending"
+	ChartAssignmentPhaseSettled  ChartAssignmentPhase = "Settled"
+	ChartAssignmentPhaseFailed   ChartAssignmentPhase = "Failed"
+	ChartAssignmentPhaseDeleting ChartAssignmentPhase = "Deleting"
+)
+
+// ConditionType enumerates the conditions reported on a ChartAssignment.
+type ConditionType string
+
+const (
+	// ConditionSettled indicates whether the last apply of the chart's
+	// resources fully succeeded.
+	ConditionSettled ConditionType = "Settled"
+	// ConditionApplyConflict indicates a server-side apply conflict that
+	// requires operator attention or Force to resolve.
+	ConditionApplyConflict ConditionType = "ApplyConflict"
+)
+
+// Condition is a single observation of a ChartAssignment's status, modeled
+// after the conventional Kubernetes condition shape.
+type Condition struct {
+	Type    ConditionType          `json:"type"`
+	Status  metav1.ConditionStatus `json:"status"`
+	Reason  string                 `json:"reason,omitempty"`
+	Message string                 `json:"message,omitempty"`
+}
+
+// ResourceSet is the fully rendered set of objects belonging to a release,
+// as produced by loadChart and applied by synk.Interface.
+type ResourceSet struct {
+	metav1.TypeMeta `json:",inline"`
+
+	Resources []runtime.RawExtension `json:"resources,omitempty"`
+}
+
+// DeepCopyObject implements runtime.Object.
+func (in *ResourceSet) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceSet)
+	*out = *in
+	if in.Resources != nil {
+		out.Resources = make([]runtime.RawExtension, len(in.Resources))
+		for i := range in.Resources {
+			in.Resources[i].DeepCopyInto(&out.Resources[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,406 @@
+// Copyright 2019 The Cloud Robotics Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chartassignment
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+	apps "github.com/googlecloudrobotics/core/src/go/pkg/apis/apps/v1alpha1"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// yamlDocumentSeparator splits a multi-document YAML string, such as one
+// produced by rendering a template that uses "---" to emit several
+// manifests, into its individual documents.
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// chartCache avoids re-downloading an OCI or HTTP chart on every reconcile
+// by keying cached archives on their content digest where we can learn it
+// up front (an OCI reference pinned to a digest, or the "digest" field of a
+// repository's index.yaml entry). Sources that can only be identified by a
+// mutable tag still share the cache, just without that guarantee.
+type chartCache struct {
+	mu    sync.Mutex
+	byKey map[string][]byte
+}
+
+func newChartCache() *chartCache {
+	return &chartCache{byKey: make(map[string][]byte)}
+}
+
+func (c *chartCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	raw, ok := c.byKey[key]
+	return raw, ok
+}
+
+func (c *chartCache) put(key string, raw []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byKey[key] = raw
+}
+
+// defaultChartCache is shared by all ChartAssignments in the process, since
+// charts are content-addressed and thus safe to reuse across releases.
+var defaultChartCache = newChartCache()
+
+// provenanceKeyring is the path to the PGP keyring used to verify chart
+// provenance files. It is set once at startup via SetProvenanceKeyring.
+var provenanceKeyring string
+
+// SetProvenanceKeyring configures the PGP keyring used to verify a chart's
+// .prov file when ChartSpec.Repository.Provenance is set.
+//
+// This package has no main of its own; the controller binary that embeds
+// it is expected to call SetProvenanceKeyring with the value of its own
+// --provenance-keyring flag during startup, before the informer is run.
+func SetProvenanceKeyring(path string) {
+	provenanceKeyring = path
+}
+
+// ociClientOptions are appended to every OCI registry client loadOCIChart
+// constructs, ahead of any AuthSecretRef-derived options. It is empty in
+// production; tests use it to point loadOCIChart at a local, plain-HTTP
+// fake registry via registry.ClientOptPlainHTTP().
+var ociClientOptions []registry.ClientOption
+
+// loadChart resolves spec's chart source (inline, an OCI registry, or an
+// HTTP chart repository), merges spec.Values on top of the chart's own
+// values.yaml, and returns the loaded chart along with the merged values
+// rendered as YAML.
+//
+// All three sources are loaded through the same Helm v3 chart loader:
+// OCI and HTTP repository charts are pulled/downloaded as Helm v3 archives,
+// so parsing them with anything else (e.g. the legacy v2 loader) would
+// reject or mis-parse their Chart.yaml.
+func loadChart(spec *apps.ChartSpec) (*chart.Chart, string, error) {
+	raw, err := loadChartArchive(spec)
+	if err != nil {
+		return nil, "", err
+	}
+	ch, err := loader.LoadArchive(bytes.NewReader(raw))
+	if err != nil {
+		return nil, "", fmt.Errorf("loading chart archive: %v", err)
+	}
+
+	merged, err := chartutil.CoalesceValues(ch, chartutil.Values(spec.Values))
+	if err != nil {
+		return nil, "", fmt.Errorf("merging chart values: %v", err)
+	}
+	valsYAML, err := merged.YAML()
+	if err != nil {
+		return nil, "", fmt.Errorf("rendering merged values: %v", err)
+	}
+	return ch, valsYAML, nil
+}
+
+// LoadChart resolves spec's chart source and merges its values exactly as
+// the ChartAssignment controller does when reconciling. It is exported for
+// chartctl, which loads a chart locally before submitting a dry-run
+// ChartAssignment. It does not render the chart's templates.
+func LoadChart(spec *apps.ChartSpec) (*chart.Chart, string, error) {
+	return loadChart(spec)
+}
+
+// renderChart renders ch's templates with valsYAML (as returned by
+// loadChart) under the given release name and namespace, and collects the
+// resulting Kubernetes objects into a ResourceSet. Empty documents and
+// NOTES.txt are skipped, matching `helm template`'s output.
+func renderChart(ch *chart.Chart, valsYAML, name, namespace string) (*apps.ResourceSet, error) {
+	vals, err := chartutil.ReadValues([]byte(valsYAML))
+	if err != nil {
+		return nil, fmt.Errorf("parsing merged chart values: %v", err)
+	}
+	renderVals, err := chartutil.ToRenderValues(ch, vals, chartutil.ReleaseOptions{
+		Name:      name,
+		Namespace: namespace,
+		IsInstall: true,
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("computing render values: %v", err)
+	}
+	rendered, err := engine.Render(ch, renderVals)
+	if err != nil {
+		return nil, fmt.Errorf("rendering chart templates: %v", err)
+	}
+
+	rs := &apps.ResourceSet{}
+	for path, content := range rendered {
+		if strings.HasSuffix(path, "NOTES.txt") {
+			continue
+		}
+		for _, doc := range yamlDocumentSeparator.Split(content, -1) {
+			if strings.TrimSpace(doc) == "" {
+				continue
+			}
+			var probe map[string]interface{}
+			if err := yaml.Unmarshal([]byte(doc), &probe); err != nil {
+				return nil, fmt.Errorf("parsing rendered template %s: %v", path, err)
+			}
+			if len(probe) == 0 {
+				continue
+			}
+			raw, err := yaml.YAMLToJSON([]byte(doc))
+			if err != nil {
+				return nil, fmt.Errorf("converting rendered template %s to JSON: %v", path, err)
+			}
+			rs.Resources = append(rs.Resources, runtime.RawExtension{Raw: raw})
+		}
+	}
+	return rs, nil
+}
+
+// loadChartArchive resolves spec's chart source to the raw bytes of a Helm
+// chart tarball.
+func loadChartArchive(spec *apps.ChartSpec) ([]byte, error) {
+	switch {
+	case spec.Inline != "":
+		raw, err := base64.StdEncoding.DecodeString(spec.Inline)
+		if err != nil {
+			return nil, fmt.Errorf("decoding inline chart: %v", err)
+		}
+		return raw, nil
+	case spec.OCI != nil:
+		return loadOCIChart(spec.OCI)
+	case spec.Repository != nil:
+		return loadRepositoryChart(spec.Repository)
+	default:
+		return nil, fmt.Errorf("chart spec sets none of inline, oci, or repository")
+	}
+}
+
+// loadOCIChart pulls a chart from an OCI registry via Helm's registry
+// client, e.g. ref "oci://registry.example.com/charts/foo:1.2.3".
+//
+// Only a digest-pinned ref (e.g. "...@sha256:...") is itself a content
+// address and can be served straight from the cache. A tag-pinned ref is
+// mutable: the registry is always asked for its current manifest, and only
+// the digest it reports is trusted as a cache key, so a moved tag is never
+// served stale content.
+func loadOCIChart(src *apps.OCIChartSource) ([]byte, error) {
+	ref := strings.TrimPrefix(src.Ref, "oci://")
+
+	if isDigestRef(ref) {
+		if raw, ok := defaultChartCache.get(ref); ok {
+			return raw, nil
+		}
+	}
+
+	opts := append([]registry.ClientOption{}, ociClientOptions...)
+	if src.AuthSecretRef != "" {
+		// The controller is responsible for materializing the referenced
+		// dockerconfigjson Secret to this path before reconciling
+		// ChartAssignments that use it, analogous to how kubelet mounts
+		// imagePullSecrets.
+		opts = append(opts, registry.ClientOptCredentialsFile(dockerConfigPath(src.AuthSecretRef)))
+	}
+	client, err := registry.NewClient(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating OCI registry client: %v", err)
+	}
+	result, err := client.Pull(ref)
+	if err != nil {
+		return nil, fmt.Errorf("pulling chart %s: %v", src.Ref, err)
+	}
+
+	if result.Manifest != nil && result.Manifest.Digest != "" {
+		if raw, ok := defaultChartCache.get(result.Manifest.Digest); ok {
+			return raw, nil
+		}
+		defaultChartCache.put(result.Manifest.Digest, result.Chart.Data)
+	}
+	if isDigestRef(ref) {
+		defaultChartCache.put(ref, result.Chart.Data)
+	}
+	return result.Chart.Data, nil
+}
+
+// isDigestRef reports whether ref is pinned to an immutable digest (e.g.
+// "registry.example.com/charts/foo@sha256:...") rather than a mutable tag.
+func isDigestRef(ref string) bool {
+	return strings.Contains(ref, "@sha256:")
+}
+
+func dockerConfigPath(secretRef string) string {
+	return "/var/run/secrets/cr-syncer.cloudrobotics.com/" + secretRef + "/.dockerconfigjson"
+}
+
+// loadRepositoryChart resolves src against the repository's index.yaml and
+// downloads the matching chart tarball.
+func loadRepositoryChart(src *apps.RepositoryChartSource) ([]byte, error) {
+	idx, err := fetchIndex(src.Repository)
+	if err != nil {
+		return nil, fmt.Errorf("fetching index.yaml from %s: %v", src.Repository, err)
+	}
+	entry, err := resolveChartVersion(idx, src.Name, src.Version)
+	if err != nil {
+		return nil, err
+	}
+	if len(entry.URLs) == 0 {
+		return nil, fmt.Errorf("chart %s %s in %s has no download URLs", src.Name, entry.Version, src.Repository)
+	}
+	chartURL := resolveChartURL(src.Repository, entry.URLs[0])
+
+	cacheKey := entry.Digest
+	if cacheKey == "" {
+		cacheKey = fmt.Sprintf("%s/%s@%s", src.Repository, src.Name, entry.Version)
+	}
+	if raw, ok := defaultChartCache.get(cacheKey); ok {
+		return raw, nil
+	}
+
+	raw, err := fetchURL(chartURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching chart %s %s: %v", src.Name, entry.Version, err)
+	}
+	if sum := sha256.Sum256(raw); entry.Digest != "" && hex.EncodeToString(sum[:]) != entry.Digest {
+		return nil, fmt.Errorf("chart %s %s failed digest verification", src.Name, entry.Version)
+	}
+	if src.Provenance {
+		if err := verifyProvenance(chartURL, src.Name, entry.Version, raw); err != nil {
+			return nil, fmt.Errorf("verifying provenance of %s %s: %v", src.Name, entry.Version, err)
+		}
+	}
+	defaultChartCache.put(cacheKey, raw)
+	return raw, nil
+}
+
+func fetchIndex(repository string) (*repo.IndexFile, error) {
+	raw, err := fetchURL(strings.TrimRight(repository, "/") + "/index.yaml")
+	if err != nil {
+		return nil, err
+	}
+	idx := &repo.IndexFile{}
+	if err := yaml.Unmarshal(raw, idx); err != nil {
+		return nil, fmt.Errorf("parsing index.yaml: %v", err)
+	}
+	idx.SortEntries()
+	return idx, nil
+}
+
+// resolveChartVersion returns the highest version of name satisfying the
+// semver constraint (e.g. "^2.1" or an exact version).
+func resolveChartVersion(idx *repo.IndexFile, name, constraint string) (*repo.ChartVersion, error) {
+	versions, ok := idx.Entries[name]
+	if !ok || len(versions) == 0 {
+		return nil, fmt.Errorf("chart %q not found in repository index", name)
+	}
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return nil, fmt.Errorf("parsing version constraint %q: %v", constraint, err)
+	}
+
+	var best *repo.ChartVersion
+	var bestVersion *semver.Version
+	for _, v := range versions {
+		sv, err := semver.NewVersion(v.Version)
+		if err != nil {
+			continue
+		}
+		if !c.Check(sv) {
+			continue
+		}
+		if bestVersion == nil || sv.GreaterThan(bestVersion) {
+			bestVersion, best = sv, v
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no version of chart %q satisfies %q", name, constraint)
+	}
+	return best, nil
+}
+
+// resolveChartURL resolves a (possibly relative) chart URL from index.yaml
+// against the repository's base URL.
+func resolveChartURL(repository, chartURL string) string {
+	if strings.Contains(chartURL, "://") {
+		return chartURL
+	}
+	return strings.TrimRight(repository, "/") + "/" + strings.TrimLeft(chartURL, "/")
+}
+
+func fetchURL(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: unexpected status %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// verifyProvenance downloads chartURL's .prov file and verifies it covers
+// raw, using the keyring configured via SetProvenanceKeyring.
+//
+// provenance.Signatory.Verify looks up the chart's digest in the signed
+// .prov message by filepath.Base(chartFile), so the chart archive must be
+// written to disk under the same basename it was signed under
+// ("<name>-<version>.tgz"), not an arbitrary temp name.
+func verifyProvenance(chartURL, name, version string, raw []byte) error {
+	if provenanceKeyring == "" {
+		return fmt.Errorf("chart requests provenance verification but no keyring is configured (see --provenance-keyring)")
+	}
+	provRaw, err := fetchURL(chartURL + ".prov")
+	if err != nil {
+		return fmt.Errorf("fetching provenance file: %v", err)
+	}
+
+	dir, err := os.MkdirTemp("", "chart-provenance")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	chartFile := filepath.Join(dir, fmt.Sprintf("%s-%s.tgz", name, version))
+	if err := os.WriteFile(chartFile, raw, 0o600); err != nil {
+		return err
+	}
+	provFile := chartFile + ".prov"
+	if err := os.WriteFile(provFile, provRaw, 0o600); err != nil {
+		return err
+	}
+
+	sig, err := provenance.NewFromKeyring(provenanceKeyring, "")
+	if err != nil {
+		return fmt.Errorf("loading keyring %s: %v", provenanceKeyring, err)
+	}
+	if _, err := sig.Verify(chartFile, provFile); err != nil {
+		return fmt.Errorf("verifying signature: %v", err)
+	}
+	return nil
+}
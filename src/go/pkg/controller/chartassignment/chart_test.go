@@ -0,0 +1,355 @@
+package chartassignment
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	apps "github.com/googlecloudrobotics/core/src/go/pkg/apis/apps/v1alpha1"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func sha256Digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func Test_resolveChartVersion(t *testing.T) {
+	idx := &repo.IndexFile{
+		Entries: map[string]repo.ChartVersions{
+			"foo": {
+				{Metadata: &chart.Metadata{Version: "1.0.0"}},
+				{Metadata: &chart.Metadata{Version: "1.2.0"}},
+				{Metadata: &chart.Metadata{Version: "2.0.0"}},
+			},
+		},
+	}
+
+	got, err := resolveChartVersion(idx, "foo", "^1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != "1.2.0" {
+		t.Fatalf("resolved version = %s, want the highest version satisfying the constraint, 1.2.0", got.Version)
+	}
+
+	if _, err := resolveChartVersion(idx, "foo", "^3.0.0"); err == nil {
+		t.Fatal("expected an error when no version satisfies the constraint")
+	}
+	if _, err := resolveChartVersion(idx, "bar", "^1.0.0"); err == nil {
+		t.Fatal("expected an error for a chart name missing from the index")
+	}
+}
+
+// newFakeChartRepository serves an index.yaml listing name at version with
+// the given tarball content under /charts/<name>-<version>.tgz, analogous
+// to buildInlineChart building a chart for the inline-source tests.
+func newFakeChartRepository(t *testing.T, name, version string, content []byte) (*httptest.Server, *int32) {
+	t.Helper()
+	var fetches int32
+	digest := sha256Digest(content)
+	digest = strings.TrimPrefix(digest, "sha256:")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.yaml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `
+apiVersion: v1
+entries:
+  %s:
+    - name: %s
+      version: %s
+      digest: %s
+      urls:
+        - /charts/%s-%s.tgz
+`, name, name, version, digest, name, version)
+	})
+	mux.HandleFunc(fmt.Sprintf("/charts/%s-%s.tgz", name, version), func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Write(content)
+	})
+	return httptest.NewServer(mux), &fetches
+}
+
+func Test_loadRepositoryChart_fetchesVerifiesAndCachesByDigest(t *testing.T) {
+	content := []byte("repository-chart-contents")
+	srv, fetches := newFakeChartRepository(t, "foo", "1.0.0", content)
+	defer srv.Close()
+
+	src := &apps.RepositoryChartSource{Repository: srv.URL, Name: "foo", Version: "1.0.0"}
+
+	raw, err := loadRepositoryChart(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != string(content) {
+		t.Fatalf("got %q, want %q", raw, content)
+	}
+	if got := atomic.LoadInt32(fetches); got != 1 {
+		t.Fatalf("expected exactly one tarball fetch, got %d", got)
+	}
+
+	if _, err := loadRepositoryChart(src); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(fetches); got != 1 {
+		t.Fatalf("a second load of the same digest should be served from cache, but the tarball was fetched %d times", got)
+	}
+}
+
+func Test_loadRepositoryChart_digestMismatchFails(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.yaml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `
+apiVersion: v1
+entries:
+  foo:
+    - name: foo
+      version: 1.0.0
+      digest: 0000000000000000000000000000000000000000000000000000000000000000
+      urls:
+        - /charts/foo-1.0.0.tgz
+`)
+	})
+	mux.HandleFunc("/charts/foo-1.0.0.tgz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tampered contents"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	src := &apps.RepositoryChartSource{Repository: srv.URL, Name: "foo", Version: "1.0.0"}
+	if _, err := loadRepositoryChart(src); err == nil {
+		t.Fatal("expected a digest verification error")
+	}
+}
+
+// testPGPIdentity is the identity the in-memory key generated by
+// newTestSigningKeyring is created under, used to select it out of the
+// keyring when constructing a signing Signatory.
+const testPGPIdentity = "test-signer@example.com"
+
+// newTestSigningKeyring generates a throwaway, passphrase-free PGP keypair
+// and writes it to an armored keyring file, returning a path usable both as
+// the signer's keyring (loaded with the identity) and, since it also
+// contains the public key, as the verifier's --provenance-keyring.
+func newTestSigningKeyring(t *testing.T) string {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Test Signer", "", testPGPIdentity, nil)
+	if err != nil {
+		t.Fatalf("generating PGP key: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatalf("opening armor writer: %v", err)
+	}
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatalf("serializing private key: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing armor writer: %v", err)
+	}
+
+	path := t.TempDir() + "/keyring.gpg"
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatalf("writing keyring: %v", err)
+	}
+	return path
+}
+
+func Test_loadRepositoryChart_provenanceVerification(t *testing.T) {
+	keyring := newTestSigningKeyring(t)
+
+	signer, err := provenance.NewFromKeyring(keyring, testPGPIdentity)
+	if err != nil {
+		t.Fatalf("loading signing keyring: %v", err)
+	}
+
+	content := []byte("provenance-chart-contents")
+	srv, _ := newFakeChartRepository(t, "foo", "1.0.0", content)
+	defer srv.Close()
+
+	// The chart must be written to disk under its real basename
+	// ("foo-1.0.0.tgz") before signing, since ClearSign's output embeds a
+	// digest keyed by filepath.Base(chartpath) and Verify looks it up the
+	// same way.
+	chartPath := t.TempDir() + "/foo-1.0.0.tgz"
+	if err := os.WriteFile(chartPath, content, 0o600); err != nil {
+		t.Fatalf("writing chart for signing: %v", err)
+	}
+	sig, err := signer.ClearSign(chartPath)
+	if err != nil {
+		t.Fatalf("signing chart: %v", err)
+	}
+
+	mux := srv.Config.Handler.(*http.ServeMux)
+	mux.HandleFunc("/charts/foo-1.0.0.tgz.prov", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, sig)
+	})
+
+	SetProvenanceKeyring(keyring)
+	defer SetProvenanceKeyring("")
+
+	src := &apps.RepositoryChartSource{Repository: srv.URL, Name: "foo", Version: "1.0.0", Provenance: true}
+	raw, err := loadRepositoryChart(src)
+	if err != nil {
+		t.Fatalf("expected a correctly signed chart to verify, got: %v", err)
+	}
+	if string(raw) != string(content) {
+		t.Fatalf("got %q, want %q", raw, content)
+	}
+}
+
+// fakeOCIRegistry is a minimal in-process OCI Distribution registry serving
+// a single manifest with a config blob and a chart content layer, enough
+// for helm.sh/helm/v3/pkg/registry.Client.Pull to resolve and download it.
+type fakeOCIRegistry struct {
+	mu             sync.Mutex
+	hits           map[string]int
+	manifest       []byte
+	manifestDigest string
+	blobs          map[string][]byte
+}
+
+func newFakeOCIRegistry(t *testing.T, chartBytes []byte) (*httptest.Server, *fakeOCIRegistry) {
+	t.Helper()
+
+	configBytes := []byte(`{"name":"foo","version":"1.0.0"}`)
+	configDigest := sha256Digest(configBytes)
+	chartDigest := sha256Digest(chartBytes)
+	manifestBytes := []byte(fmt.Sprintf(`{
+  "schemaVersion": 2,
+  "config": {"mediaType": %q, "digest": %q, "size": %d},
+  "layers": [{"mediaType": %q, "digest": %q, "size": %d}]
+}`, registry.ConfigMediaType, configDigest, len(configBytes), registry.ChartLayerMediaType, chartDigest, len(chartBytes)))
+
+	reg := &fakeOCIRegistry{
+		hits:           make(map[string]int),
+		manifest:       manifestBytes,
+		manifestDigest: sha256Digest(manifestBytes),
+		blobs: map[string][]byte{
+			configDigest: configBytes,
+			chartDigest:  chartBytes,
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		reg.mu.Lock()
+		reg.hits[r.Method+" "+r.URL.Path]++
+		reg.mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case strings.Contains(r.URL.Path, "/manifests/"):
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			w.Header().Set("Docker-Content-Digest", reg.manifestDigest)
+			if r.Method != http.MethodHead {
+				w.Write(reg.manifest)
+			}
+		case strings.Contains(r.URL.Path, "/blobs/"):
+			digest := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+			data, ok := reg.blobs[digest]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", digest)
+			if r.Method != http.MethodHead {
+				w.Write(data)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	return httptest.NewServer(mux), reg
+}
+
+func (r *fakeOCIRegistry) count(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hits[key]
+}
+
+func withPlainHTTPOCIClient(t *testing.T) {
+	t.Helper()
+	ociClientOptions = []registry.ClientOption{registry.ClientOptPlainHTTP()}
+	t.Cleanup(func() { ociClientOptions = nil })
+}
+
+func Test_loadOCIChart_tagRefAlwaysResolvesManifest(t *testing.T) {
+	withPlainHTTPOCIClient(t)
+
+	chartBytes := []byte("oci-tag-chart-contents")
+	srv, reg := newFakeOCIRegistry(t, chartBytes)
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	src := &apps.OCIChartSource{Ref: "oci://" + host + "/charts/foo:1.0.0"}
+	manifestPath := "GET /v2/charts/foo/manifests/1.0.0"
+
+	raw, err := loadOCIChart(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != string(chartBytes) {
+		t.Fatalf("got %q, want %q", raw, chartBytes)
+	}
+	first := reg.count(manifestPath)
+	if first == 0 {
+		t.Fatalf("expected the manifest endpoint to be hit for %s", manifestPath)
+	}
+
+	if _, err := loadOCIChart(src); err != nil {
+		t.Fatal(err)
+	}
+	if second := reg.count(manifestPath); second <= first {
+		t.Fatalf("a mutable tag ref must re-resolve the manifest on every call instead of trusting a Ref-keyed cache entry; hits went from %d to %d", first, second)
+	}
+}
+
+func Test_loadOCIChart_digestRefServedFromCache(t *testing.T) {
+	withPlainHTTPOCIClient(t)
+
+	chartBytes := []byte("oci-digest-chart-contents")
+	srv, reg := newFakeOCIRegistry(t, chartBytes)
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	ref := "oci://" + host + "/charts/bar@" + reg.manifestDigest
+	src := &apps.OCIChartSource{Ref: ref}
+	manifestPath := "GET /v2/charts/bar/manifests/" + reg.manifestDigest
+
+	if _, err := loadOCIChart(src); err != nil {
+		t.Fatal(err)
+	}
+	hits := reg.count(manifestPath)
+	if hits == 0 {
+		t.Fatalf("expected the first pull of a digest ref to hit the registry at %s", manifestPath)
+	}
+
+	raw, err := loadOCIChart(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != string(chartBytes) {
+		t.Fatalf("got %q, want %q", raw, chartBytes)
+	}
+	if got := reg.count(manifestPath); got != hits {
+		t.Fatalf("a digest-pinned ref should be served from cache on the second call, but the registry was hit %d times total (first call: %d)", got, hits)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 The Cloud Robotics Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package chartassignment implements the controller for the ChartAssignment
+// CRD: it loads the assigned Helm chart, renders its resources, and applies
+// them to the assigned cluster via synk.
+package chartassignment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apps "github.com/googlecloudrobotics/core/src/go/pkg/apis/apps/v1alpha1"
+	"github.com/googlecloudrobotics/core/src/go/pkg/synk"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// defaultServerSideApply is the rollout strategy used by ChartAssignments
+// that do not set Spec.RolloutStrategy.ServerSideApply, set once at startup
+// via SetDefaultApplyStrategy from the controller's --default-apply-strategy
+// flag.
+var defaultServerSideApply = false
+
+// SetDefaultApplyStrategy configures whether ChartAssignments apply their
+// resources via server-side apply by default.
+//
+// This package has no main of its own; the controller binary that embeds
+// it is expected to call SetDefaultApplyStrategy with the value of its own
+// --default-apply-strategy flag during startup, before the informer is run.
+func SetDefaultApplyStrategy(serverSideApply bool) {
+	defaultServerSideApply = serverSideApply
+}
+
+// release reconciles a single ChartAssignment's desired state with the
+// cluster, via the synk applier.
+type release struct {
+	synk     synk.Interface
+	recorder record.EventRecorder
+}
+
+// updateSynk loads as's chart, renders its resources, and applies them via
+// synk, returning the resulting ResourceSet. Conflicts reported by a
+// server-side apply are recorded in as.Status (Conflicts and
+// ConditionApplyConflict) and are also returned as an error. With
+// Spec.RolloutStrategy.Force set, they are passed to synk as opts.Force.
+//
+// If Spec.DryRun is set, nothing is applied: the diff synk would have
+// applied is stored in as.Status.Preview instead, and updateSynk returns a
+// nil ResourceSet.
+func (r *release) updateSynk(as *apps.ChartAssignment) (*apps.ResourceSet, error) {
+	ch, vals, err := loadChart(&as.Spec.Chart)
+	if err != nil {
+		r.recorder.Eventf(as, corev1.EventTypeWarning, "ChartLoadFailed", "Failed to load chart: %v", err)
+		return nil, fmt.Errorf("loading chart for %s: %v", as.Name, err)
+	}
+	want, err := renderChart(ch, vals, as.Name, as.Spec.NamespaceName)
+	if err != nil {
+		r.recorder.Eventf(as, corev1.EventTypeWarning, "ChartRenderFailed", "Failed to render chart: %v", err)
+		return nil, fmt.Errorf("rendering chart for %s: %v", as.Name, err)
+	}
+
+	opts := synk.ApplyOptions{
+		FieldManager: "chartassignment/" + as.Name,
+		Force:        as.Spec.RolloutStrategy.Force,
+	}
+
+	if as.Spec.DryRun {
+		preview, err := r.synk.Diff(context.Background(), as.Name, want, opts)
+		if err != nil {
+			r.recorder.Eventf(as, corev1.EventTypeWarning, "DiffFailed", "Failed to compute dry-run diff: %v", err)
+			return nil, fmt.Errorf("diffing %s: %v", as.Name, err)
+		}
+		as.Status.Preview = preview
+		return nil, nil
+	}
+
+	apply := r.synk.Apply
+	if serverSideApply(as) {
+		apply = r.synk.ApplySSA
+	}
+
+	rs, err := apply(context.Background(), as.Name, want, opts)
+	var conflictErr *synk.ConflictError
+	if errors.As(err, &conflictErr) {
+		setApplyConflict(as, conflictErr)
+		r.recorder.Eventf(as, corev1.EventTypeWarning, "ApplyConflict", "Server-side apply rejected by %d field conflict(s)", len(conflictErr.Conflicts))
+		return nil, fmt.Errorf("applying %s: %v", as.Name, err)
+	}
+	if err != nil {
+		r.recorder.Eventf(as, corev1.EventTypeWarning, "ApplyFailed", "Failed to apply chart: %v", err)
+		return nil, fmt.Errorf("applying %s: %v", as.Name, err)
+	}
+	as.Status.Preview = nil
+	clearApplyConflict(as)
+	return rs, nil
+}
+
+// serverSideApply reports whether as should be applied via server-side
+// apply, falling back to the controller-wide default when unset.
+func serverSideApply(as *apps.ChartAssignment) bool {
+	return as.Spec.RolloutStrategy.ServerSideApply || defaultServerSideApply
+}
+
+// setApplyConflict records a rejected server-side apply's offending field
+// paths and owners on as.Status, for the operator to resolve or override
+// with Spec.RolloutStrategy.Force.
+func setApplyConflict(as *apps.ChartAssignment, err *synk.ConflictError) {
+	as.Status.Conflicts = make([]apps.FieldConflict, len(err.Conflicts))
+	for i, c := range err.Conflicts {
+		as.Status.Conflicts[i] = apps.FieldConflict{FieldPath: c.FieldPath, Manager: c.Manager}
+	}
+	setCondition(as, apps.Condition{
+		Type:    apps.ConditionApplyConflict,
+		Status:  metav1.ConditionTrue,
+		Reason:  "FieldConflict",
+		Message: err.Error(),
+	})
+}
+
+func clearApplyConflict(as *apps.ChartAssignment) {
+	as.Status.Conflicts = nil
+	setCondition(as, apps.Condition{
+		Type:   apps.ConditionApplyConflict,
+		Status: metav1.ConditionFalse,
+	})
+}
+
+// setCondition replaces the condition of cond.Type on as.Status, if any,
+// with cond.
+func setCondition(as *apps.ChartAssignment, cond apps.Condition) {
+	for i, c := range as.Status.Conditions {
+		if c.Type == cond.Type {
+			as.Status.Conditions[i] = cond
+			return
+		}
+	}
+	as.Status.Conditions = append(as.Status.Conditions, cond)
+}
+
+// deleteSynk removes all resources previously applied for as.
+func (r *release) deleteSynk(as *apps.ChartAssignment) error {
+	if err := r.synk.Delete(context.Background(), as.Name); err != nil {
+		r.recorder.Eventf(as, corev1.EventTypeWarning, "DeleteFailed", "Failed to delete chart: %v", err)
+		return fmt.Errorf("deleting %s: %v", as.Name, err)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package chartassignment
 
 import (
+	"context"
 	"encoding/base64"
 	"io/ioutil"
 	"os"
@@ -10,8 +11,10 @@ import (
 
 	"github.com/golang/mock/gomock"
 	apps "github.com/googlecloudrobotics/core/src/go/pkg/apis/apps/v1alpha1"
+	"github.com/googlecloudrobotics/core/src/go/pkg/synk"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
 	"k8s.io/client-go/tools/record"
-	"k8s.io/helm/pkg/chartutil"
 )
 
 func writeFile(t *testing.T, fn string, s string) {
@@ -23,6 +26,14 @@ func writeFile(t *testing.T, fn string, s string) {
 
 func buildInlineChart(t *testing.T, chart, values string) string {
 	t.Helper()
+	return buildInlineChartWithTemplates(t, chart, values, nil)
+}
+
+// buildInlineChartWithTemplates is buildInlineChart plus templates, a map
+// of template file name (e.g. "configmap.yaml") to its content, written
+// under the chart's templates/ directory.
+func buildInlineChartWithTemplates(t *testing.T, chart, values string, templates map[string]string) string {
+	t.Helper()
 
 	tmpdir, err := ioutil.TempDir("", "buildInlineChart")
 	if err != nil {
@@ -32,8 +43,16 @@ func buildInlineChart(t *testing.T, chart, values string) string {
 
 	writeFile(t, path.Join(tmpdir, "Chart.yaml"), chart)
 	writeFile(t, path.Join(tmpdir, "values.yaml"), values)
+	if len(templates) > 0 {
+		if err := os.Mkdir(path.Join(tmpdir, "templates"), 0777); err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range templates {
+			writeFile(t, path.Join(tmpdir, "templates", name), content)
+		}
+	}
 
-	ch, err := chartutil.LoadDir(tmpdir)
+	ch, err := loader.LoadDir(tmpdir)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -58,6 +77,7 @@ func verifyValues(t *testing.T, have string, wantValues chartutil.Values) {
 
 func Test_loadChart_mergesValues(t *testing.T) {
 	chart := buildInlineChart(t, `
+apiVersion: v2
 name: testchart
 version: 2.1.0
 	`, `
@@ -96,6 +116,7 @@ func Test_updateSynk_callsApply(t *testing.T) {
 	defer ctrl.Finish()
 
 	chart := buildInlineChart(t, `
+apiVersion: v2
 name: testchart
 version: 2.1.0
 	`, `
@@ -130,11 +151,163 @@ spec:
 	r.updateSynk(&as)
 }
 
+func Test_updateSynk_rendersChartResources(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	chart := buildInlineChartWithTemplates(t, `
+apiVersion: v2
+name: testchart
+version: 2.1.0
+	`, `
+message: hello
+	`, map[string]string{
+		"configmap.yaml": `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}-cm
+data:
+  message: {{ .Values.message | quote }}
+		`,
+	})
+
+	var as apps.ChartAssignment
+	unmarshalYAML(t, &as, `
+metadata:
+  name: test-assignment-1
+spec:
+  namespaceName: default
+	`)
+	as.Spec.Chart.Inline = chart
+
+	mockSynk := NewMockInterface(ctrl)
+	r := &release{
+		synk:     mockSynk,
+		recorder: &record.FakeRecorder{},
+	}
+
+	var got *apps.ResourceSet
+	mockSynk.EXPECT().Apply(gomock.Any(), "test-assignment-1", gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, want *apps.ResourceSet, _ synk.ApplyOptions) (*apps.ResourceSet, error) {
+			got = want
+			return want, nil
+		}).Times(1)
+
+	if _, err := r.updateSynk(&as); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Resources) != 1 {
+		t.Fatalf("want 1 rendered resource, got %d: %+v", len(got.Resources), got.Resources)
+	}
+	if !strings.Contains(string(got.Resources[0].Raw), "test-assignment-1-cm") {
+		t.Fatalf("rendered resource does not contain the expected ConfigMap name: %s", got.Resources[0].Raw)
+	}
+}
+
+func Test_updateSynk_callsApplySSA(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	chart := buildInlineChart(t, `
+apiVersion: v2
+name: testchart
+version: 2.1.0
+	`, `
+foo1:
+  baz1: "hello"
+bar1: 3
+	`)
+
+	var as apps.ChartAssignment
+	unmarshalYAML(t, &as, `
+metadata:
+  name: test-assignment-1
+spec:
+  chart:
+    values:
+      bar1: 4
+      bar2:
+        baz2: test
+  rolloutStrategy:
+    serverSideApply: true
+	`)
+	as.Spec.Chart.Inline = chart
+
+	mockSynk := NewMockInterface(ctrl)
+	r := &release{
+		synk:     mockSynk,
+		recorder: &record.FakeRecorder{},
+	}
+
+	rs := &apps.ResourceSet{}
+	mockSynk.EXPECT().ApplySSA(gomock.Any(), "test-assignment-1", gomock.Any(), gomock.Any()).Return(rs, nil).Times(1)
+
+	// With rolloutStrategy.serverSideApply set, updateSynk must call
+	// ApplySSA instead of Apply.
+	r.updateSynk(&as)
+}
+
+func Test_updateSynk_dryRun_storesPreview(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	chart := buildInlineChart(t, `
+apiVersion: v2
+name: testchart
+version: 2.1.0
+	`, `
+foo1:
+  baz1: "hello"
+bar1: 3
+	`)
+
+	var as apps.ChartAssignment
+	unmarshalYAML(t, &as, `
+metadata:
+  name: test-assignment-1
+spec:
+  dryRun: true
+  chart:
+    values:
+      bar1: 4
+      bar2:
+        baz2: test
+	`)
+	as.Spec.Chart.Inline = chart
+
+	mockSynk := NewMockInterface(ctrl)
+	r := &release{
+		synk:     mockSynk,
+		recorder: &record.FakeRecorder{},
+	}
+
+	preview := &apps.Preview{
+		Added: []apps.ObjectRef{{APIVersion: "v1", Kind: "ConfigMap", Name: "testchart-cm"}},
+	}
+	mockSynk.EXPECT().Diff(gomock.Any(), "test-assignment-1", gomock.Any(), gomock.Any()).Return(preview, nil).Times(1)
+
+	// With spec.dryRun set, updateSynk must call Diff instead of Apply or
+	// ApplySSA, and store the result in status.preview rather than
+	// returning a ResourceSet.
+	rs, err := r.updateSynk(&as)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs != nil {
+		t.Fatalf("dry-run updateSynk should return a nil ResourceSet, got %+v", rs)
+	}
+	if as.Status.Preview != preview {
+		t.Fatalf("status.preview = %+v, want %+v", as.Status.Preview, preview)
+	}
+}
+
 func Test_deleteSynk_callsDelete(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	chart := buildInlineChart(t, `
+apiVersion: v2
 name: testchart
 version: 2.1.0
 	`, `
@@ -0,0 +1,17 @@
+package chartassignment
+
+import (
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+// unmarshalYAML decodes the YAML document s into v, failing the test on
+// error. It is used throughout this package's tests to build fixture
+// ChartAssignments without the boilerplate of constructing them in Go.
+func unmarshalYAML(t *testing.T, v interface{}, s string) {
+	t.Helper()
+	if err := yaml.Unmarshal([]byte(s), v); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 The Cloud Robotics Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package synk applies sets of Kubernetes resources on behalf of a named
+// release, either via a client-side three-way merge or Kubernetes
+// server-side apply.
+package synk
+
+import (
+	"context"
+	"fmt"
+
+	apps "github.com/googlecloudrobotics/core/src/go/pkg/apis/apps/v1alpha1"
+)
+
+// ApplyOptions configures a single Apply or ApplySSA call.
+type ApplyOptions struct {
+	// FieldManager identifies the owner of applied fields. Only used by
+	// ApplySSA; it is conventionally "chartassignment/<name>".
+	FieldManager string
+	// Force lets ApplySSA take ownership of fields currently managed by
+	// another field manager instead of failing with a ConflictError.
+	Force bool
+}
+
+// Conflict describes a single field that a server-side apply could not take
+// ownership of without Force.
+type Conflict struct {
+	FieldPath string
+	Manager   string
+}
+
+// ConflictError is returned by ApplySSA when the server reports ownership
+// conflicts on one or more fields and opts.Force was not set.
+type ConflictError struct {
+	Conflicts []Conflict
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("server-side apply rejected due to %d field conflict(s)", len(e.Conflicts))
+}
+
+// Interface applies and deletes a release's resources against a cluster.
+// Implementations must be safe for concurrent use.
+type Interface interface {
+	// Apply reconciles the live resources for the named release with
+	// want via a client-side three-way merge against the release's
+	// previously applied state, returning the resulting ResourceSet.
+	Apply(ctx context.Context, name string, want *apps.ResourceSet, opts ApplyOptions) (*apps.ResourceSet, error)
+	// ApplySSA reconciles the live resources for the named release with
+	// want via a Kubernetes server-side apply PATCH (Content-Type
+	// application/apply-patch+yaml) instead of a client-side merge. It
+	// returns a *ConflictError if the server reports ownership conflicts
+	// and opts.Force is not set.
+	ApplySSA(ctx context.Context, name string, want *apps.ResourceSet, opts ApplyOptions) (*apps.ResourceSet, error)
+	// Delete removes all resources previously applied for the named
+	// release.
+	Delete(ctx context.Context, name string) error
+	// Diff computes the same reconciliation Apply or ApplySSA would
+	// perform for the named release against want, without changing
+	// anything on the cluster, and returns it as a structured Preview.
+	Diff(ctx context.Context, name string, want *apps.ResourceSet, opts ApplyOptions) (*apps.Preview, error)
+}